@@ -6,15 +6,70 @@ import (
 )
 
 type Config struct {
-	ProxyUrl             string   `yaml:"proxy_url"`
-	BaseUrl              string   `yaml:"base_url"`
-	TelegramToken        string   `yaml:"telegram_token"`
-	OpenAIKey            string   `yaml:"openai_api_key"`
-	Temperature          float32  `yaml:"temperature"`
-	AllowedUsers         []string `yaml:"allowed_telegram_usernames"`
-	FreeChatCount        int      `yaml:"free_chat_count"`
-	GoogleSearchKey      string   `yaml:"google_search_key"`
-	GoogleSearchEngineID string   `yaml:"google_search_engine_id"`
+	ProxyUrl             string           `yaml:"proxy_url"`
+	BaseUrl              string           `yaml:"base_url"`
+	TelegramToken        string           `yaml:"telegram_token"`
+	OpenAIKey            string           `yaml:"openai_api_key"`
+	Temperature          float32          `yaml:"temperature"`
+	AllowedUsers         []string         `yaml:"allowed_telegram_usernames"`
+	FreeChatCount        int              `yaml:"free_chat_count"`
+	FreeChatReset        string           `yaml:"free_chat_reset"`
+	GoogleSearchKey      string           `yaml:"google_search_key"`
+	GoogleSearchEngineID string           `yaml:"google_search_engine_id"`
+	SearchProviders      []string         `yaml:"search_providers"`
+	SearxngURL           string           `yaml:"searxng_url"`
+	SearchKeywords       []string         `yaml:"search_keywords"`
+	SearchCacheSize      int              `yaml:"search_cache_size"`
+	SearchCacheTTL       int              `yaml:"search_cache_ttl"`
+	PageCacheTTL         int              `yaml:"page_cache_ttl"`
+	SearchCachePersist   bool             `yaml:"search_cache_persist"`
+	StorageBackend       string           `yaml:"storage_backend"`
+	StoragePath          string           `yaml:"storage_path"`
+	Providers            []ProviderConfig `yaml:"providers"`
+	Models               []ModelConfig    `yaml:"models"`
+	DefaultModel         string           `yaml:"default_model"`
+	ImageModel           string           `yaml:"image_model"`
+	WebhookURL           string           `yaml:"webhook_url"`
+	WebhookListen        string           `yaml:"webhook_listen"`
+	WebhookSecret        string           `yaml:"webhook_secret"`
+	WebhookCert          string           `yaml:"webhook_cert"`
+	WebhookKey           string           `yaml:"webhook_key"`
+	WebhookRegister      bool             `yaml:"webhook_register"`
+	ShutdownTimeout      int              `yaml:"shutdown_timeout"`
+	TranscriptionModel   string           `yaml:"transcription_model"`
+	SpeechModel          string           `yaml:"tts_model"`
+	SpeechVoice          string           `yaml:"tts_voice"`
+	SpeechSpeed          float64          `yaml:"tts_speed"`
+	KnowledgeDir         string           `yaml:"knowledge_dir"`
+	KnowledgeIndex       string           `yaml:"knowledge_index"`
+	KnowledgeTopK        int              `yaml:"knowledge_top_k"`
+	EmbeddingModel       string           `yaml:"embedding_model"`
+	EmbeddingProvider    string           `yaml:"embedding_provider"`
+}
+
+// ProviderConfig 模型后端配置，type 可选 openai、azure、local
+type ProviderConfig struct {
+	Name        string            `yaml:"name"`
+	Type        string            `yaml:"type"`
+	BaseURL     string            `yaml:"base_url"`
+	APIKey      string            `yaml:"api_key"`
+	APIVersion  string            `yaml:"api_version"`
+	Deployments map[string]string `yaml:"deployments"`
+}
+
+// ModelConfig 模型目录中的一项，alias 用于 /model 命令，name 为上游模型名，
+// provider 为空时使用默认的 openai，价格单位为美元每千 token，vision 表示模型可以接收图片，
+// tools 表示模型支持工具调用
+type ModelConfig struct {
+	Alias           string  `yaml:"alias"`
+	Name            string  `yaml:"name"`
+	Provider        string  `yaml:"provider"`
+	ContextSize     int     `yaml:"context_size"`
+	MaxOutputTokens int     `yaml:"max_output_tokens"`
+	InputPrice      float64 `yaml:"input_price"`
+	OutputPrice     float64 `yaml:"output_price"`
+	Vision          bool    `yaml:"vision"`
+	Tools           bool    `yaml:"tools"`
 }
 
 func ReadConfig() (Config, error) {
@@ -0,0 +1,34 @@
+package docs
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Chunk 将文本按行合并成不超过 size 个字符的块，单行超长时按字符硬切
+func Chunk(text string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if chunk := strings.TrimSpace(current.String()); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		current.Reset()
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		for utf8.RuneCountInString(line) > size {
+			flush()
+			runes := []rune(line)
+			chunks = append(chunks, string(runes[:size]))
+			line = string(runes[size:])
+		}
+		if utf8.RuneCountInString(current.String())+utf8.RuneCountInString(line) > size {
+			flush()
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	flush()
+	return chunks
+}
@@ -0,0 +1,130 @@
+package docs
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/ledongthuc/pdf"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrUnsupported = errors.New("unsupported document type")
+
+// textExtensions 直接按文本读取的文件类型，包括常见的源代码文件
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".rst": true, ".csv": true, ".log": true,
+	".json": true, ".yaml": true, ".yml": true, ".toml": true, ".ini": true, ".xml": true,
+	".html": true, ".htm": true, ".css": true, ".sql": true, ".sh": true, ".bat": true, ".ps1": true,
+	".go": true, ".py": true, ".js": true, ".ts": true, ".jsx": true, ".tsx": true, ".vue": true,
+	".java": true, ".kt": true, ".scala": true, ".c": true, ".h": true, ".cpp": true, ".hpp": true,
+	".cc": true, ".cs": true, ".rs": true, ".rb": true, ".php": true, ".swift": true, ".m": true,
+	".lua": true, ".pl": true, ".r": true, ".dart": true, ".proto": true, ".gradle": true,
+}
+
+// Supported 判断文件名是否为可以提取文字的文档
+func Supported(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return textExtensions[ext] || ext == ".pdf" || ext == ".docx"
+}
+
+// Extract 按扩展名从文件内容中提取文字
+func Extract(name string, data []byte) (string, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch {
+	case ext == ".pdf":
+		return extractPDF(data)
+	case ext == ".docx":
+		return extractDocx(data)
+	case textExtensions[ext]:
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("%s is not valid UTF-8 text", name)
+		}
+		return string(data), nil
+	default:
+		return "", ErrUnsupported
+	}
+}
+
+func extractPDF(data []byte) (text string, err error) {
+	// 解析库遇到损坏的文件会 panic
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("parse pdf: %v", r)
+		}
+	}()
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", err
+	}
+	content, err := io.ReadAll(plain)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// extractDocx 读取 word/document.xml 中的文字，段落之间换行
+func extractDocx(data []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	for _, file := range archive.File {
+		if file.Name != "word/document.xml" {
+			continue
+		}
+		content, err := file.Open()
+		if err != nil {
+			return "", err
+		}
+		defer content.Close()
+		return docxText(content)
+	}
+	return "", errors.New("word/document.xml not found in docx")
+}
+
+func docxText(r io.Reader) (string, error) {
+	var text strings.Builder
+	decoder := xml.NewDecoder(r)
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return text.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				text.WriteString("\t")
+			case "br", "cr":
+				text.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(t)
+			}
+		}
+	}
+}
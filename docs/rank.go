@@ -0,0 +1,95 @@
+package docs
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Rank 按与 query 的词项重合度为 texts 打分，返回得分最高的至多 k 个下标，没有任何重合的文本不返回。
+// 英文按单词切分，中文等没有空格的文字按相邻两字切分。
+func Rank(query string, texts []string, k int) []int {
+	queryTerms := map[string]bool{}
+	for _, term := range terms(query) {
+		queryTerms[term] = true
+	}
+	if len(queryTerms) == 0 || len(texts) == 0 {
+		return nil
+	}
+
+	counts := make([]map[string]int, len(texts))
+	documentFrequency := map[string]int{}
+	for i, text := range texts {
+		counts[i] = map[string]int{}
+		for _, term := range terms(text) {
+			counts[i][term]++
+		}
+		for term := range counts[i] {
+			documentFrequency[term]++
+		}
+	}
+
+	type scored struct {
+		index int
+		score float64
+	}
+	var results []scored
+	for i := range texts {
+		score := 0.0
+		for term := range queryTerms {
+			if tf := counts[i][term]; tf > 0 {
+				idf := math.Log(1 + float64(len(texts))/float64(documentFrequency[term]))
+				score += (1 + math.Log(float64(tf))) * idf
+			}
+		}
+		if score > 0 {
+			results = append(results, scored{i, score})
+		}
+	}
+	sort.SliceStable(results, func(a, b int) bool { return results[a].score > results[b].score })
+
+	var indexes []int
+	for i := 0; i < len(results) && i < k; i++ {
+		indexes = append(indexes, results[i].index)
+	}
+	return indexes
+}
+
+// terms 切分出用于匹配的词项，重复出现的词项会重复返回
+func terms(text string) []string {
+	var result []string
+	var word []rune
+	var han []rune
+	flushWord := func() {
+		if len(word) > 1 {
+			result = append(result, strings.ToLower(string(word)))
+		}
+		word = word[:0]
+	}
+	flushHan := func() {
+		if len(han) == 1 {
+			result = append(result, string(han))
+		}
+		for i := 0; i+1 < len(han); i++ {
+			result = append(result, string(han[i:i+2]))
+		}
+		han = han[:0]
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return result
+}
@@ -0,0 +1,82 @@
+package gptMessage
+
+import (
+	"bytes"
+	"context"
+	"duolaGPT/markdown"
+	"duolaGPT/provider"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// TranscriptionModel 语音转写使用的模型，main 中可按配置替换
+var TranscriptionModel = openai.Whisper1
+
+// 语音合成的模型、音色和语速，main 中可按配置替换，语速为 0 时使用接口默认值
+var (
+	SpeechModel = openai.TTSModel1
+	SpeechVoice = openai.VoiceAlloy
+	SpeechSpeed float64
+)
+
+const (
+	// audioTimeout 单次转写或合成的超时时间
+	audioTimeout = 2 * time.Minute
+	// speechInputLimit 语音合成接口单次输入的字符上限
+	speechInputLimit = 4096
+)
+
+// Transcribe 使用默认 provider 将音频转写为文字，fileName 的扩展名用于告诉接口音频格式
+func Transcribe(providers *provider.Registry, audio []byte, fileName string) (string, error) {
+	ctx, cancel := context.WithTimeout(streamsCtx, audioTimeout)
+	defer cancel()
+
+	response, err := providers.Default().CreateTranscription(ctx, openai.AudioRequest{
+		Model:    TranscriptionModel,
+		FilePath: fileName,
+		Reader:   bytes.NewReader(audio),
+	})
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Transcribed %s (%d bytes) with %s", fileName, len(audio), TranscriptionModel)
+	return strings.TrimSpace(response.Text), nil
+}
+
+// Synthesize 使用默认 provider 将回复合成为 OGG/Opus 语音，超过接口输入上限的回复按段落切分成多段
+func Synthesize(providers *provider.Registry, text string) ([][]byte, error) {
+	var voices [][]byte
+	// UTF-16 长度不小于字符数，按 UTF-16 切分可以保证每段不超过字符上限
+	for _, part := range markdown.Split(markdown.PlainText(text), speechInputLimit) {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		voice, err := synthesizePart(providers, part)
+		if err != nil {
+			return voices, err
+		}
+		voices = append(voices, voice)
+	}
+	return voices, nil
+}
+
+func synthesizePart(providers *provider.Registry, input string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(streamsCtx, audioTimeout)
+	defer cancel()
+
+	speech, err := providers.Default().CreateSpeech(ctx, openai.CreateSpeechRequest{
+		Model:          SpeechModel,
+		Input:          input,
+		Voice:          SpeechVoice,
+		ResponseFormat: openai.SpeechResponseFormatOpus,
+		Speed:          SpeechSpeed,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer speech.Close()
+	return io.ReadAll(speech)
+}
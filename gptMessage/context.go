@@ -0,0 +1,127 @@
+package gptMessage
+
+import (
+	"duolaGPT/conf"
+	"duolaGPT/tokenizer"
+	"duolaGPT/variables"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"regexp"
+	"strings"
+)
+
+// fitContextWindow 从最早的非 system 消息开始丢弃，直到 prompt 加上 maxTokens 能放进模型的上下文。
+// system 消息和最新的一条消息始终保留，带工具调用的回复和它的工具结果一起保留或丢弃。
+// 返回保留的消息、被丢弃的消息以及 prompt 的 token 数。
+func fitContextWindow(model conf.ModelConfig, messages []openai.ChatCompletionMessage, maxTokens int) ([]openai.ChatCompletionMessage, []openai.ChatCompletionMessage, int) {
+	budget := model.ContextSize - maxTokens
+	promptTokens := tokenizer.CountMessages(model.Name, messages)
+
+	var kept, dropped []openai.ChatCompletionMessage
+	for start := 0; start < len(messages); {
+		end := start + 1
+		if len(messages[start].ToolCalls) > 0 {
+			for end < len(messages) && messages[end].Role == openai.ChatMessageRoleTool {
+				end++
+			}
+		}
+		group := messages[start:end]
+		if promptTokens > budget && messages[start].Role != openai.ChatMessageRoleSystem && end < len(messages) {
+			for _, message := range group {
+				promptTokens -= tokenizer.CountMessage(model.Name, message)
+			}
+			dropped = append(dropped, group...)
+		} else {
+			kept = append(kept, group...)
+		}
+		start = end
+	}
+	return kept, dropped, promptTokens
+}
+
+// legacyLanguage 旧版本写入 system prompt 的固定语言要求
+var legacyLanguage = regexp.MustCompile(`Respond conversationally in [A-Za-z]+\.`)
+
+// withLanguage 把 system prompt 中的语言占位符替换为回复语言，language 为空时使用默认语言。
+// 旧的 system prompt 中写死的语言要求一并替换；自定义 prompt 没有语言要求且会话设置了语言时，
+// 在开头的 system prompt 之后插入一条语言要求
+func withLanguage(messages []openai.ChatCompletionMessage, language string) []openai.ChatCompletionMessage {
+	resolved := language
+	if resolved == "" {
+		resolved = variables.DefaultLanguage
+	}
+	if len(messages) > 0 && messages[0].Role == openai.ChatMessageRoleSystem {
+		prompt := messages[0].Content
+		switch {
+		case strings.Contains(prompt, variables.LanguagePlaceholder):
+			prompt = strings.ReplaceAll(prompt, variables.LanguagePlaceholder, resolved)
+		case legacyLanguage.MatchString(prompt):
+			prompt = legacyLanguage.ReplaceAllLiteralString(prompt, fmt.Sprintf("Respond conversationally in %s.", resolved))
+		}
+		if prompt != messages[0].Content {
+			result := append([]openai.ChatCompletionMessage(nil), messages...)
+			result[0].Content = prompt
+			return result
+		}
+	}
+	if language == "" {
+		return messages
+	}
+
+	instruction := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: fmt.Sprintf("Always respond in %s.", language),
+	}
+	insertAt := 0
+	if len(messages) > 0 && messages[0].Role == openai.ChatMessageRoleSystem {
+		insertAt = 1
+	}
+	result := make([]openai.ChatCompletionMessage, 0, len(messages)+1)
+	result = append(result, messages[:insertAt]...)
+	result = append(result, instruction)
+	return append(result, messages[insertAt:]...)
+}
+
+// userMessage 构造用户消息，有图片时使用 MultiContent
+func userMessage(text string, images []string) openai.ChatCompletionMessage {
+	if len(images) == 0 {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: text}
+	}
+	parts := []openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: text}}
+	for _, image := range images {
+		parts = append(parts, openai.ChatMessagePart{
+			Type:     openai.ChatMessagePartTypeImageURL,
+			ImageURL: &openai.ChatMessageImageURL{URL: image, Detail: openai.ImageURLDetailAuto},
+		})
+	}
+	return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, MultiContent: parts}
+}
+
+// MessageText 返回消息的文字内容，图片用占位符表示
+func MessageText(message openai.ChatCompletionMessage) string {
+	if len(message.MultiContent) == 0 {
+		return message.Content
+	}
+	var texts []string
+	for _, part := range message.MultiContent {
+		if part.Type == openai.ChatMessagePartTypeImageURL {
+			texts = append(texts, "[图片]")
+		} else {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, " ")
+}
+
+// withoutImages 把带图片的消息转换为纯文字消息
+func withoutImages(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	result := make([]openai.ChatCompletionMessage, len(messages))
+	for i, message := range messages {
+		if len(message.MultiContent) > 0 {
+			message.Content = MessageText(message)
+			message.MultiContent = nil
+		}
+		result[i] = message
+	}
+	return result
+}
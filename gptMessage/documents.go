@@ -0,0 +1,85 @@
+package gptMessage
+
+import (
+	"duolaGPT/conf"
+	"duolaGPT/docs"
+	"duolaGPT/tokenizer"
+	"duolaGPT/variables"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"log"
+	"strings"
+)
+
+// documentChunks 每次请求最多注入的文档片段数
+const documentChunks = 4
+
+// documentContext 从会话上传的文档中挑选与问题最相关的片段，没有文档时返回空字符串。
+// 注入内容最多占用 budget 个 token，超出时丢弃得分较低的片段。
+func documentContext(chatID int64, query string, model conf.ModelConfig, budget int) string {
+	documents, err := variables.Documents.Get(chatID)
+	if err != nil {
+		log.Printf("Failed to load documents for %d: %v", chatID, err)
+		return ""
+	}
+	if len(documents) == 0 {
+		return ""
+	}
+
+	type source struct {
+		name  string
+		part  int
+		total int
+	}
+	var texts []string
+	var sources []source
+	for _, document := range documents {
+		for i, chunk := range document.Chunks {
+			texts = append(texts, chunk)
+			sources = append(sources, source{document.Name, i + 1, len(document.Chunks)})
+		}
+	}
+	indexes := docs.Rank(query, texts, documentChunks)
+	if len(indexes) == 0 {
+		// 问题与文档没有字面重合（例如"总结一下"）时使用各文档开头的片段
+		for i := 0; i < len(texts) && len(indexes) < documentChunks; i++ {
+			if sources[i].part == 1 {
+				indexes = append(indexes, i)
+			}
+		}
+	}
+
+	for ; len(indexes) > 0; indexes = indexes[:len(indexes)-1] {
+		var builder strings.Builder
+		builder.WriteString("The user attached documents to this conversation. Relevant excerpts are below; use them when they help answer and mention which document you relied on.\n")
+		for n, index := range indexes {
+			fmt.Fprintf(&builder, "\n[%d] %s (part %d/%d):\n%s\n", n+1, sources[index].name, sources[index].part, sources[index].total, texts[index])
+		}
+		if reference := builder.String(); tokenizer.CountText(model.Name, reference) <= budget {
+			return reference
+		}
+	}
+	return ""
+}
+
+// withReference 在最新的一条用户消息之前插入参考资料，参考资料只作用于本次请求，不写入对话历史。
+// 插在用户消息之前而不是末尾，避免把工具调用和工具结果隔开
+func withReference(messages []openai.ChatCompletionMessage, reference string) []openai.ChatCompletionMessage {
+	if reference == "" || len(messages) == 0 {
+		return messages
+	}
+	insertAt := len(messages) - 1
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == openai.ChatMessageRoleUser {
+			insertAt = i
+			break
+		}
+	}
+	result := make([]openai.ChatCompletionMessage, 0, len(messages)+1)
+	result = append(result, messages[:insertAt]...)
+	result = append(result, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: reference,
+	})
+	return append(result, messages[insertAt:]...)
+}
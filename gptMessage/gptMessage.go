@@ -3,6 +3,12 @@ package gptMessage
 import (
 	"bytes"
 	"context"
+	"duolaGPT/conf"
+	"duolaGPT/models"
+	"duolaGPT/provider"
+	"duolaGPT/tokenizer"
+	"duolaGPT/tools"
+	"duolaGPT/utils"
 	"duolaGPT/variables"
 	"encoding/base64"
 	"errors"
@@ -14,92 +20,234 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
-var mu sync.Mutex
 var TemperatureNum float32
 
-func GenerateTextStreamWithGPT(client *openai.Client, inputText string, chatID int64, model string) (chan string, error) {
-	variables.ConversationHistory[chatID] = append(variables.ConversationHistory[chatID], openai.ChatCompletionMessage{
-		Role:    "user",
-		Content: inputText,
+// ErrMessageTooLong 最新的消息加上 system prompt 已经超出模型的上下文
+var ErrMessageTooLong = errors.New("message too long for the model context")
+
+// streamsCtx 所有流式请求的父 context，关闭服务超时时统一取消
+var (
+	streamsCtx, cancelStreams = context.WithCancel(context.Background())
+	interrupted               atomic.Bool
+)
+
+// InFlight 进行中的回复和后台摘要，关闭服务时等待它们结束后再关闭存储
+var InFlight sync.WaitGroup
+
+// InterruptStreams 中止所有进行中的流式输出，之后 Interrupted 返回 true
+func InterruptStreams() {
+	interrupted.Store(true)
+	cancelStreams()
+}
+
+// Interrupted 返回流式输出是否因服务关闭被中止
+func Interrupted() bool {
+	return interrupted.Load()
+}
+
+// Temperature 返回会话的温度，未设置时使用配置中的默认值
+func Temperature(user variables.User) float32 {
+	if user.Temperature != nil {
+		return *user.Temperature
+	}
+	return TemperatureNum
+}
+
+// RequestContext 创建一次回复使用的 context 并记录到会话，/stop 或关闭服务时取消。
+// 在请求模型前联网搜索时使用，搜索也能被 /stop 中止
+func RequestContext(chatID int64) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(streamsCtx)
+	variables.UserSettingsMap.Update(chatID, func(user *variables.User) {
+		user.CurrentContext = &cancel
 	})
+	return ctx, cancel
+}
 
-	request := openai.ChatCompletionRequest{
-		Model:       model,
-		Messages:    variables.ConversationHistory[chatID],
-		Temperature: TemperatureNum,
-		MaxTokens:   4096,
-		TopP:        1,
-		Stream:      true,
+// GenerateTextStreamWithGPT 追加用户消息并流式请求模型，images 为随消息发送的图片（data URL）。
+// 模型请求调用工具时执行工具并把结果交给模型继续回答，最多 maxToolDepth 轮
+func GenerateTextStreamWithGPT(ctx context.Context, providers *provider.Registry, inputText string, chatID int64, model conf.ModelConfig, images ...string) (chan string, error) {
+	variables.ConversationHistory.Append(chatID, userMessage(inputText, images))
+
+	user := variables.UserSettingsMap.Get(chatID)
+	// 文档和知识库片段合计最多占用可用上下文的一半，其余留给对话历史
+	referenceBudget := (model.ContextSize - model.MaxOutputTokens) / 2
+	reference := documentContext(chatID, inputText, model, referenceBudget)
+	if passages := knowledgeContext(ctx, chatID, inputText, model, referenceBudget-tokenizer.CountText(model.Name, reference)); passages != "" {
+		reference = strings.TrimSpace(reference + "\n\n" + passages)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	mu.Lock()
-	user := variables.UserSettingsMap[chatID]
-	user.CurrentContext = &cancel
-	variables.UserSettingsMap[chatID] = user
+	p := providers.ForModel(model)
+	request, err := chatRequest(p, chatID, user, model, reference, toolDefinitions(model, user))
+	if err != nil {
+		// 放不进上下文的消息不留在历史中，否则之后的每次请求都会失败
+		variables.ConversationHistory.Update(chatID, func(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+			if n := len(messages); n > 0 && messages[n-1].Role == openai.ChatMessageRoleUser {
+				return messages[:n-1]
+			}
+			return messages
+		})
+		return nil, err
+	}
 
-	mu.Unlock()
+	ctx, cancel := context.WithCancel(ctx)
+	variables.UserSettingsMap.Update(chatID, func(user *variables.User) {
+		user.CurrentContext = &cancel
+	})
 	responseData := make(chan string)
 	go func() {
+		// 无论正常结束、出错还是被取消，都先保存已生成的内容再关闭 channel
+		defer close(responseData)
+		defer CompleteResponse(chatID)
+		defer cancel()
 
-		stream, err := client.CreateChatCompletionStream(ctx, request)
-		if err != nil {
-			fmt.Printf("ChatCompletionStream error: %v\n", err)
-			return
+		// 提问前搜索到的和搜索工具返回的来源，回复结束后合并成一个链接列表附在末尾
+		citations, ok := tools.CitationsFrom(ctx)
+		if !ok {
+			citations = &tools.Citations{}
+			ctx = tools.WithCitations(ctx, citations)
 		}
-		defer stream.Close()
-		for {
-			select {
-			case <-ctx.Done(): // 检查上下文是否被取消或超时
-				fmt.Println("Context cancelled, closing stream!!!!!")
+		var reply strings.Builder
+		for depth := 1; ; depth++ {
+			content, calls, ok := streamCompletion(ctx, p, request, chatID, responseData)
+			reply.WriteString(content)
+			if !ok {
 				return
-			default:
-				// 正常的流处理逻辑
-				response, err := stream.Recv()
-				if errors.Is(err, io.EOF) {
-					fmt.Println("\nStream finished")
-					return
+			}
+			if len(calls) == 0 {
+				if sources := citations.Sources(); len(sources) > 0 && strings.TrimSpace(reply.String()) != "" {
+					// 来源列表只展示给用户，不计入模型回复
+					responseData <- utils.SourcesFooter(reply.String(), sources)
 				}
+				return
+			}
+			runTools(ctx, chatID, calls)
 
-				if err != nil {
-					fmt.Printf("\nStream error: %v\n", err)
-					return
-				}
+			var definitions []openai.Tool
+			if depth < maxToolDepth {
+				definitions = toolDefinitions(model, user)
+			}
+			if request, err = chatRequest(p, chatID, user, model, reference, definitions); err != nil {
+				log.Printf("Chat %d: %v", chatID, err)
+				return
+			}
+		}
+	}()
 
-				responseData <- response.Choices[0].Delta.Content
+	return responseData, nil
+}
 
-				mu.Lock()
-				user = variables.UserSettingsMap[chatID]
-				user.CurrentMessageBuffer += response.Choices[0].Delta.Content
-				variables.UserSettingsMap[chatID] = user
-				mu.Unlock()
+// chatRequest 按上下文窗口裁剪对话历史并构造请求，只剩 system 和最新消息仍然放不下时返回 ErrMessageTooLong
+func chatRequest(p provider.Provider, chatID int64, user variables.User, model conf.ModelConfig, reference string, definitions []openai.Tool) (openai.ChatCompletionRequest, error) {
+	maxTokens := model.MaxOutputTokens
+	referenceTokens := tokenizer.CountText(model.Name, reference)
+	// 只裁剪本次请求使用的副本，保存的历史不变：换回上下文更长的模型后仍然可以使用。
+	// 开启自动摘要时，被挤出的消息在摘要写回后才从历史中删除
+	messages, dropped, promptTokens := fitContextWindow(model, variables.ConversationHistory.Get(chatID), maxTokens+referenceTokens)
+	promptTokens += referenceTokens
+	if len(dropped) > 0 && user.AutoSummary && startSummary(chatID) {
+		InFlight.Add(1)
+		go func() {
+			defer InFlight.Done()
+			defer finishSummary(chatID)
+			summarizeDropped(p, chatID, model, dropped)
+		}()
+	}
+	remaining := model.ContextSize - promptTokens
+	if remaining <= 0 {
+		return openai.ChatCompletionRequest{}, fmt.Errorf("%w: prompt %d tokens, context %d tokens", ErrMessageTooLong, promptTokens, model.ContextSize)
+	}
+	// 只剩 system 和最新消息仍然放不下时，压缩回复长度
+	if remaining < maxTokens {
+		maxTokens = remaining
+	}
+	log.Printf("Chat %d model %s: prompt %d tokens ($%.4f), max completion %d tokens, dropped %d messages", chatID, model.Alias, promptTokens, models.Cost(model, promptTokens, 0), maxTokens, len(dropped))
 
-				if response.Choices[0].FinishReason != "" {
-					close(responseData)
-					CompleteResponse(chatID)
-				}
-			}
+	if !model.Vision {
+		// 切换到不支持图片的模型后，历史中的图片只保留文字部分
+		messages = withoutImages(messages)
+	}
+	if len(definitions) == 0 {
+		// 不提供工具时，历史中的工具调用转换为普通文字
+		messages = withoutToolCalls(messages)
+	}
+	// 语言设置只作用于本次请求，不写入对话历史
+	messages = withLanguage(messages, user.Language)
+	messages = withReference(messages, reference)
+
+	return openai.ChatCompletionRequest{
+		Model:       model.Name,
+		Messages:    messages,
+		Temperature: Temperature(user),
+		MaxTokens:   maxTokens,
+		TopP:        1,
+		Stream:      true,
+		Tools:       definitions,
+	}, nil
+}
+
+// streamCompletion 把模型输出的文字逐段写入 out，返回本轮输出的文字和模型请求的工具调用。
+// 请求失败或被取消时 ok 为 false
+func streamCompletion(ctx context.Context, p provider.Provider, request openai.ChatCompletionRequest, chatID int64, out chan<- string) (content string, calls []openai.ToolCall, ok bool) {
+	stream, err := p.CreateChatCompletionStream(ctx, request)
+	if err != nil {
+		fmt.Printf("ChatCompletionStream error: %v\n", err)
+		return "", nil, false
+	}
+	defer stream.Close()
+
+	var builder strings.Builder
+	var accumulator tools.Accumulator
+	for {
+		select {
+		case <-ctx.Done(): // 检查上下文是否被取消或超时
+			fmt.Println("Context cancelled, closing stream!!!!!")
+			return builder.String(), nil, false
+		default:
 		}
 
-	}()
+		// 正常的流处理逻辑
+		response, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			fmt.Println("\nStream finished")
+			return builder.String(), accumulator.Calls(), true
+		}
+		if err != nil {
+			fmt.Printf("\nStream error: %v\n", err)
+			return builder.String(), nil, false
+		}
+		if len(response.Choices) == 0 {
+			continue
+		}
 
-	return responseData, nil
+		delta := response.Choices[0].Delta
+		accumulator.Add(delta.ToolCalls)
+		builder.WriteString(delta.Content)
+		variables.UserSettingsMap.Update(chatID, func(user *variables.User) {
+			user.CurrentMessageBuffer += delta.Content
+		})
+		out <- delta.Content
+
+		if response.Choices[0].FinishReason != "" {
+			return builder.String(), accumulator.Calls(), true
+		}
+	}
 }
 
-func GenerateImgWithGPT(client *openai.Client, inputText string, chatID int64, model string) (tgbotapi.PhotoConfig, error) {
+func GenerateImgWithGPT(providers *provider.Registry, inputText string, chatID int64, model conf.ModelConfig) (tgbotapi.PhotoConfig, error) {
 	ctx := context.Background()
 
 	imageRequest := openai.ImageRequest{
-		Model:          model,
+		Model:          model.Name,
 		Prompt:         inputText,
 		Size:           openai.CreateImageSize1024x1024,
 		ResponseFormat: openai.CreateImageResponseFormatB64JSON,
 		N:              1,
 	}
 
-	imageResponse, err := client.CreateImage(ctx, imageRequest)
+	imageResponse, err := providers.ForModel(model).CreateImage(ctx, imageRequest)
 	if err != nil {
 		log.Printf("Failed to create image: %v", err)
 		return tgbotapi.PhotoConfig{}, err
@@ -138,14 +286,20 @@ func GenerateImgWithGPT(client *openai.Client, inputText string, chatID int64, m
 }
 
 func CompleteResponse(chatID int64) {
-	mu.Lock()
-	user := variables.UserSettingsMap[chatID]
-	generatedText := user.CurrentMessageBuffer
-	user.CurrentMessageBuffer = ""
-	variables.UserSettingsMap[chatID] = user
-	mu.Unlock()
+	var generatedText string
+	variables.UserSettingsMap.Update(chatID, func(user *variables.User) {
+		generatedText = user.CurrentMessageBuffer
+		user.CurrentMessageBuffer = ""
+	})
 	generatedText = strings.TrimSpace(generatedText)
-	variables.ConversationHistory[chatID] = append(variables.ConversationHistory[chatID], openai.ChatCompletionMessage{
+	// 流结束和 /stop 都会调用，缓冲区已经被取走时不再追加空消息
+	if generatedText == "" {
+		return
+	}
+	model := models.Resolve(variables.UserSettingsMap.Get(chatID).Model)
+	completionTokens := tokenizer.CountText(model.Name, generatedText)
+	log.Printf("Chat %d model %s: completion %d tokens ($%.4f)", chatID, model.Alias, completionTokens, models.Cost(model, 0, completionTokens))
+	variables.ConversationHistory.Append(chatID, openai.ChatCompletionMessage{
 		Role:    "assistant",
 		Content: generatedText,
 	})
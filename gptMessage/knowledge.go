@@ -0,0 +1,58 @@
+package gptMessage
+
+import (
+	"context"
+	"duolaGPT/conf"
+	"duolaGPT/knowledge"
+	"duolaGPT/tokenizer"
+	"duolaGPT/variables"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Knowledge 本地知识库，未配置 knowledge_dir 时为 nil
+var Knowledge *knowledge.Base
+
+// KnowledgeTopK 每次请求从知识库中检索的片段数
+var KnowledgeTopK = 4
+
+// knowledgeContext 从知识库中检索与问题最相关的片段并附上来源文件名，未启用时返回空字符串。
+// 注入内容最多占用 budget 个 token，超出时丢弃相似度较低的片段。
+func knowledgeContext(ctx context.Context, chatID int64, query string, model conf.ModelConfig, budget int) string {
+	if Knowledge == nil || variables.UserSettingsMap.Get(chatID).KnowledgeDisabled || strings.TrimSpace(query) == "" {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	passages, err := Knowledge.Search(ctx, query, KnowledgeTopK)
+	if err != nil {
+		log.Printf("Failed to search knowledge base: %v", err)
+		return ""
+	}
+
+	for ; len(passages) > 0; passages = passages[:len(passages)-1] {
+		var builder strings.Builder
+		builder.WriteString("Passages retrieved from the team knowledge base are below. Use them when they are relevant and cite the source file names you relied on.\n")
+		for n, passage := range passages {
+			fmt.Fprintf(&builder, "\n[%d] source: %s\n%s\n", n+1, passage.Source, passage.Text)
+		}
+		if reference := builder.String(); tokenizer.CountText(model.Name, reference) <= budget {
+			return reference
+		}
+	}
+	return ""
+}
+
+// ReindexKnowledge 增量重建知识库索引，服务关闭时中止
+func ReindexKnowledge() (updated, removed int, err error) {
+	if Knowledge == nil {
+		return 0, 0, nil
+	}
+	start := time.Now()
+	updated, removed, err = Knowledge.Reindex(streamsCtx)
+	files, passages := Knowledge.Size()
+	log.Printf("Knowledge reindex: %d updated, %d removed, %d files / %d passages indexed in %v", updated, removed, files, passages, time.Since(start))
+	return updated, removed, err
+}
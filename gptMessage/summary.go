@@ -0,0 +1,117 @@
+package gptMessage
+
+import (
+	"context"
+	"duolaGPT/conf"
+	"duolaGPT/provider"
+	"duolaGPT/variables"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const summaryInstruction = "Condense the following conversation into a concise summary that keeps facts, decisions, open questions and any user preferences. " +
+	"If a previous summary is included, merge it into the new one. Write in the same language as the conversation and keep it under 300 words."
+
+// isSummary 判断消息是否为自动生成的摘要
+func isSummary(message openai.ChatCompletionMessage) bool {
+	return message.Role == openai.ChatMessageRoleSystem && strings.HasPrefix(message.Content, variables.SummaryPrefix)
+}
+
+// summarizing 正在生成摘要的会话，同一会话同时只生成一份摘要，避免两份摘要互相覆盖
+var summarizing = struct {
+	sync.Mutex
+	chats map[int64]bool
+}{chats: make(map[int64]bool)}
+
+// startSummary 标记会话开始生成摘要，已有摘要在生成时返回 false
+func startSummary(chatID int64) bool {
+	summarizing.Lock()
+	defer summarizing.Unlock()
+	if summarizing.chats[chatID] {
+		return false
+	}
+	summarizing.chats[chatID] = true
+	return true
+}
+
+func finishSummary(chatID int64) {
+	summarizing.Lock()
+	defer summarizing.Unlock()
+	delete(summarizing.chats, chatID)
+}
+
+// withoutMessages 按顺序从 history 中删除 dropped 中的消息，返回删除后的历史和删除的条数
+func withoutMessages(history, dropped []openai.ChatCompletionMessage) ([]openai.ChatCompletionMessage, int) {
+	var kept []openai.ChatCompletionMessage
+	removed := 0
+	for _, message := range history {
+		if removed < len(dropped) && reflect.DeepEqual(message, dropped[removed]) {
+			removed++
+			continue
+		}
+		kept = append(kept, message)
+	}
+	return kept, removed
+}
+
+// summarizeDropped 在后台把被挤出上下文的消息与已有摘要合并成一条新的摘要，写回对话历史并删除这些消息
+func summarizeDropped(p provider.Provider, chatID int64, model conf.ModelConfig, dropped []openai.ChatCompletionMessage) {
+	var transcript strings.Builder
+	for _, message := range variables.ConversationHistory.Get(chatID) {
+		if isSummary(message) {
+			transcript.WriteString("Previous summary: " + strings.TrimPrefix(message.Content, variables.SummaryPrefix) + "\n")
+		}
+	}
+	for _, message := range dropped {
+		transcript.WriteString(fmt.Sprintf("%s: %s\n", message.Role, MessageText(message)))
+	}
+
+	ctx, cancel := context.WithTimeout(streamsCtx, 2*time.Minute)
+	defer cancel()
+	response, err := p.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: model.Name,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: summaryInstruction},
+			{Role: openai.ChatMessageRoleUser, Content: transcript.String()},
+		},
+		Temperature: 0.2,
+		MaxTokens:   512,
+	})
+	if err != nil {
+		log.Printf("Failed to summarize conversation %d: %v", chatID, err)
+		return
+	}
+	if len(response.Choices) == 0 {
+		return
+	}
+	summary := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: variables.SummaryPrefix + strings.TrimSpace(response.Choices[0].Message.Content),
+	}
+
+	variables.ConversationHistory.Update(chatID, func(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+		// 摘要替换被挤出的消息；这些消息已不在历史中（如 /clear 之后）时丢弃摘要
+		messages, removed := withoutMessages(messages, dropped)
+		if removed == 0 {
+			return messages
+		}
+		for i, message := range messages {
+			if isSummary(message) {
+				messages[i] = summary
+				return messages
+			}
+		}
+		// 没有旧摘要时放在开头的 system prompt 之后
+		insertAt := 0
+		if len(messages) > 0 && messages[0].Role == openai.ChatMessageRoleSystem {
+			insertAt = 1
+		}
+		return append(messages[:insertAt], append([]openai.ChatCompletionMessage{summary}, messages[insertAt:]...)...)
+	})
+	log.Printf("Chat %d: summarized %d dropped messages", chatID, len(dropped))
+}
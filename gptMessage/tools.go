@@ -0,0 +1,91 @@
+package gptMessage
+
+import (
+	"context"
+	"duolaGPT/conf"
+	"duolaGPT/tools"
+	"duolaGPT/variables"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"log"
+	"strings"
+)
+
+// Tools 提供给模型的工具，为 nil 时不使用工具调用
+var Tools *tools.Registry
+
+// maxToolDepth 一次回复中最多连续调用工具的轮数，达到后不再提供工具，要求模型直接回答
+const maxToolDepth = 5
+
+// ToolEnabled 判断 model 是否可以调用名为 name 的工具
+func ToolEnabled(model conf.ModelConfig, name string) bool {
+	return model.Tools && Tools != nil && Tools.Has(name)
+}
+
+// toolDefinitions 返回本次请求提供给模型的工具，会话关闭联网搜索时不提供搜索工具
+func toolDefinitions(model conf.ModelConfig, user variables.User) []openai.Tool {
+	if !model.Tools || Tools == nil {
+		return nil
+	}
+	var exclude []string
+	if user.SearchDisabled {
+		exclude = append(exclude, tools.WebSearchName)
+	}
+	return Tools.Definitions(exclude...)
+}
+
+// runTools 执行模型的工具调用，把调用和每个调用的结果一起写入对话历史。
+// 本轮已经输出的文字随工具调用一起保存，不再由 CompleteResponse 重复保存
+func runTools(ctx context.Context, chatID int64, calls []openai.ToolCall) {
+	var content string
+	variables.UserSettingsMap.Update(chatID, func(user *variables.User) {
+		content = user.CurrentMessageBuffer
+		user.CurrentMessageBuffer = ""
+	})
+	for i := range calls {
+		// Index 只在流式分片中使用
+		calls[i].Index = nil
+	}
+
+	// 每个调用都必须有对应的结果，否则之后的请求会被拒绝，即使已经被取消也要写入
+	results := []openai.ChatCompletionMessage{{
+		Role:      openai.ChatMessageRoleAssistant,
+		Content:   strings.TrimSpace(content),
+		ToolCalls: calls,
+	}}
+	for _, call := range calls {
+		log.Printf("Chat %d calling tool %s with %s", chatID, call.Function.Name, call.Function.Arguments)
+		results = append(results, openai.ChatCompletionMessage{
+			Role:       openai.ChatMessageRoleTool,
+			Content:    Tools.Execute(ctx, call),
+			ToolCallID: call.ID,
+		})
+	}
+	// 执行期间收到的新消息不能插在调用和结果之间，全部执行完后一次写入
+	variables.ConversationHistory.Update(chatID, func(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+		return append(messages, results...)
+	})
+}
+
+// withoutToolCalls 把历史中的工具调用和结果转换为普通文字，用于不提供工具的请求
+func withoutToolCalls(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	result := make([]openai.ChatCompletionMessage, 0, len(messages))
+	for _, message := range messages {
+		switch {
+		case len(message.ToolCalls) > 0:
+			var calls []string
+			for _, call := range message.ToolCalls {
+				calls = append(calls, fmt.Sprintf("[called tool %s with %s]", call.Function.Name, call.Function.Arguments))
+			}
+			message.Content = strings.TrimSpace(message.Content + "\n" + strings.Join(calls, "\n"))
+			message.ToolCalls = nil
+		case message.Role == openai.ChatMessageRoleTool:
+			message = openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "Tool result: " + message.Content,
+			}
+		}
+		result = append(result, message)
+	}
+	return result
+}
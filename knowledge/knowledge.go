@@ -0,0 +1,224 @@
+package knowledge
+
+import (
+	"context"
+	"duolaGPT/docs"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// chunkSize 知识库文档切分的块大小（字符）
+	chunkSize = 1000
+	// embeddingBatch 每次请求 embedding 的文本块数
+	embeddingBatch = 64
+)
+
+var ErrIndexing = errors.New("knowledge base is being indexed")
+
+// EmbedFunc 将一批文本转换为向量
+type EmbedFunc func(ctx context.Context, input []string) ([][]float32, error)
+
+// Passage 知识库中的一个文本块，Source 为相对于知识库目录的文件路径
+type Passage struct {
+	Source string    `json:"source"`
+	Text   string    `json:"text"`
+	Vector []float32 `json:"vector"`
+}
+
+// fileEntry 一个文件的索引结果，ModTime 用于增量重建
+type fileEntry struct {
+	ModTime  time.Time `json:"mod_time"`
+	Passages []Passage `json:"passages"`
+}
+
+// Base 本地知识库：目录中的文档切分后计算向量，索引以 JSON 文件保存在 indexPath
+type Base struct {
+	dir       string
+	indexPath string
+	embed     EmbedFunc
+
+	mu       sync.RWMutex
+	files    map[string]fileEntry
+	indexing sync.Mutex
+}
+
+// Open 加载已有的索引文件，文件不存在时从空索引开始
+func Open(dir, indexPath string, embed EmbedFunc) (*Base, error) {
+	base := &Base{dir: dir, indexPath: indexPath, embed: embed, files: map[string]fileEntry{}}
+	data, err := os.ReadFile(indexPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return base, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &base.files); err != nil {
+		return nil, err
+	}
+	return base, nil
+}
+
+// Reindex 扫描知识库目录，只为新增或修改时间变化的文件重新计算向量，并移除已删除的文件。
+// 已有重建在进行时返回 ErrIndexing。
+func (b *Base) Reindex(ctx context.Context) (updated, removed int, err error) {
+	if !b.indexing.TryLock() {
+		return 0, 0, ErrIndexing
+	}
+	defer b.indexing.Unlock()
+
+	b.mu.RLock()
+	files := make(map[string]fileEntry, len(b.files))
+	for source, entry := range b.files {
+		files[source] = entry
+	}
+	b.mu.RUnlock()
+
+	seen := map[string]bool{}
+	err = filepath.WalkDir(b.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !docs.Supported(path) {
+			return err
+		}
+		source, err := filepath.Rel(b.dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		seen[source] = true
+		if existing, ok := files[source]; ok && existing.ModTime.Equal(info.ModTime()) {
+			return nil
+		}
+		passages, err := b.indexFile(ctx, path, source)
+		if err != nil {
+			// 单个文件失败不影响其他文件，下次重建时再试
+			log.Printf("Failed to index %s: %v", source, err)
+			return ctx.Err()
+		}
+		files[source] = fileEntry{ModTime: info.ModTime(), Passages: passages}
+		updated++
+		return nil
+	})
+	if err != nil {
+		return updated, 0, err
+	}
+	for source := range files {
+		if !seen[source] {
+			delete(files, source)
+			removed++
+		}
+	}
+
+	b.mu.Lock()
+	b.files = files
+	b.mu.Unlock()
+	if updated > 0 || removed > 0 {
+		err = b.save(files)
+	}
+	return updated, removed, err
+}
+
+func (b *Base) indexFile(ctx context.Context, path, source string) ([]Passage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text, err := docs.Extract(path, data)
+	if err != nil {
+		return nil, err
+	}
+	chunks := docs.Chunk(text, chunkSize)
+	passages := make([]Passage, 0, len(chunks))
+	for start := 0; start < len(chunks); start += embeddingBatch {
+		end := start + embeddingBatch
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		vectors, err := b.embed(ctx, chunks[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for i, vector := range vectors {
+			passages = append(passages, Passage{Source: source, Text: chunks[start+i], Vector: vector})
+		}
+	}
+	return passages, nil
+}
+
+// save 先写临时文件再改名，避免写到一半时进程退出损坏索引
+func (b *Base) save(files map[string]fileEntry) error {
+	data, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	tmp := b.indexPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.indexPath)
+}
+
+// Search 返回与 query 余弦相似度最高的 k 个文本块
+func (b *Base) Search(ctx context.Context, query string, k int) ([]Passage, error) {
+	vectors, err := b.embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	queryVector := vectors[0]
+
+	type scored struct {
+		passage Passage
+		score   float64
+	}
+	var results []scored
+	b.mu.RLock()
+	for _, entry := range b.files {
+		for _, passage := range entry.Passages {
+			results = append(results, scored{passage, cosine(queryVector, passage.Vector)})
+		}
+	}
+	b.mu.RUnlock()
+	sort.Slice(results, func(i, j int) bool { return results[i].score > results[j].score })
+
+	var passages []Passage
+	for i := 0; i < len(results) && i < k; i++ {
+		passages = append(passages, results[i].passage)
+	}
+	return passages, nil
+}
+
+// Size 返回已索引的文件数和文本块数
+func (b *Base) Size() (files, passages int) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, entry := range b.files {
+		passages += len(entry.Passages)
+	}
+	return len(b.files), passages
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
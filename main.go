@@ -1,15 +1,28 @@
 package main
 
 import (
+	"context"
 	"duolaGPT/conf"
 	"duolaGPT/gptMessage"
+	"duolaGPT/knowledge"
 	"duolaGPT/message"
+	"duolaGPT/models"
+	"duolaGPT/provider"
+	"duolaGPT/store"
+	"duolaGPT/tools"
+	"duolaGPT/utils"
+	"duolaGPT/variables"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	openai "github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func createHTTPClient(proxyURL string) *http.Client {
@@ -29,11 +42,12 @@ func createHTTPClient(proxyURL string) *http.Client {
 	}
 }
 
-func createOpenAIClient(msgConf conf.Config, httpClient *http.Client) *openai.Client {
-	config := openai.DefaultConfig(msgConf.OpenAIKey)
-	config.BaseURL = msgConf.BaseUrl
-	config.HTTPClient = httpClient
-	return openai.NewClientWithConfig(config)
+// cacheTTL 将配置的分钟数转换为时长，未配置时使用默认值，负数表示不缓存
+func cacheTTL(minutes int, fallback time.Duration) time.Duration {
+	if minutes == 0 {
+		return fallback
+	}
+	return time.Duration(minutes) * time.Minute
 }
 
 func createTelegramBot(msgConf conf.Config, httpClient *http.Client) (*tgbotapi.BotAPI, error) {
@@ -51,13 +65,98 @@ func main() {
 	}
 
 	gptMessage.TemperatureNum = msgConf.Temperature
+	if msgConf.TranscriptionModel != "" {
+		gptMessage.TranscriptionModel = msgConf.TranscriptionModel
+	}
+	if msgConf.SpeechModel != "" {
+		gptMessage.SpeechModel = openai.SpeechModel(msgConf.SpeechModel)
+	}
+	if msgConf.SpeechVoice != "" {
+		gptMessage.SpeechVoice = openai.SpeechVoice(msgConf.SpeechVoice)
+	}
+	gptMessage.SpeechSpeed = msgConf.SpeechSpeed
 	message.FreeChatCount = msgConf.FreeChatCount
+	message.AllowedUsers = msgConf.AllowedUsers
 	if msgConf.BaseUrl == "" {
 		msgConf.BaseUrl = "https://openai.com/v1"
 	}
 
+	storage, err := store.Open(msgConf.StorageBackend, msgConf.StoragePath)
+	if err != nil {
+		log.Fatalf("Failed to open storage: %v", err)
+		return
+	}
+	conversationStore, err := storage.Conversations()
+	if err != nil {
+		log.Fatalf("Failed to open conversation store: %v", err)
+		return
+	}
+	variables.ConversationHistory = conversationStore
+	settingsStore, err := storage.Settings()
+	if err != nil {
+		log.Fatalf("Failed to open settings store: %v", err)
+		return
+	}
+	variables.UserSettingsMap = variables.NewSettingsRepository(settingsStore)
+	documentStore, err := storage.Documents()
+	if err != nil {
+		log.Fatalf("Failed to open document store: %v", err)
+		return
+	}
+	variables.Documents = documentStore
+	quotaStore, err := storage.Quota()
+	if err != nil {
+		log.Fatalf("Failed to open quota store: %v", err)
+		return
+	}
+	cacheSize := msgConf.SearchCacheSize
+	if cacheSize <= 0 {
+		cacheSize = utils.DefaultCacheSize
+	}
+	if msgConf.SearchCachePersist && msgConf.StorageBackend != store.BackendBolt {
+		log.Printf("search_cache_persist requires storage_backend bolt, caching in memory only")
+	}
+	cacheStore, err := storage.Cache(cacheSize, msgConf.SearchCachePersist)
+	if err != nil {
+		log.Fatalf("Failed to open search cache: %v", err)
+		return
+	}
+	utils.Cache = utils.NewSearchCache(cacheStore,
+		cacheTTL(msgConf.SearchCacheTTL, utils.DefaultQueryTTL), cacheTTL(msgConf.PageCacheTTL, utils.DefaultPageTTL))
+
 	httpClient := createHTTPClient(msgConf.ProxyUrl)
-	openAIClient := createOpenAIClient(msgConf, httpClient)
+	if err := models.Init(msgConf); err != nil {
+		log.Fatalf("Failed to init models: %v", err)
+		return
+	}
+	providers, err := provider.NewRegistry(msgConf, httpClient)
+	if err != nil {
+		log.Fatalf("Failed to init providers: %v", err)
+		return
+	}
+
+	if msgConf.KnowledgeDir != "" {
+		if err := openKnowledge(msgConf, providers); err != nil {
+			log.Fatalf("Failed to open knowledge base: %v", err)
+			return
+		}
+	}
+
+	// 抓取网页的客户端只允许连接公网地址, 用户通过 /url 或搜索结果不能访问本机和内网
+	fetchClient, err := utils.NewFetchClient(msgConf.ProxyUrl)
+	if err != nil {
+		log.Fatalf("Failed to parse proxy URL: %v", err)
+		return
+	}
+	utils.HTTPClient = fetchClient
+	search, err := utils.NewSearchProvider(msgConf, httpClient)
+	if err != nil {
+		log.Fatalf("Failed to init search providers: %v", err)
+		return
+	}
+	utils.Search = search
+	// 支持工具调用的模型自行决定是否联网搜索
+	gptMessage.Tools = tools.NewRegistry(tools.NewWebSearch())
 
 	bot, err := createTelegramBot(msgConf, httpClient)
 	if err != nil {
@@ -67,59 +166,188 @@ func main() {
 	bot.Debug = false
 	log.Printf("Authorized on account %s", bot.Self.UserName)
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-
-	updates := bot.GetUpdatesChan(u)
-	if err != nil {
-		log.Fatalf("Failed to get updates channel: %v", err)
+	var updates tgbotapi.UpdatesChannel
+	var webhookServer *http.Server
+	if msgConf.WebhookURL != "" {
+		updates, webhookServer, err = startWebhook(bot, msgConf)
+		if err != nil {
+			log.Fatalf("Failed to start webhook: %v", err)
+		}
+	} else {
+		// 之前注册过 webhook 时 getUpdates 会被拒绝
+		if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+			log.Printf("Failed to delete webhook: %v", err)
+		}
+		u := tgbotapi.NewUpdate(0)
+		u.Timeout = 60
+		updates = bot.GetUpdatesChan(u)
 	}
-	userManager := message.NewUserManager()
-	for update := range updates {
-		go func(update tgbotapi.Update) {
+	userManager := message.NewUserManager(quotaStore)
 
-			if update.Message == nil {
-				return
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 回复和后台摘要共用一个 WaitGroup，全部结束后才关闭存储
+	inFlight := &gptMessage.InFlight
+	dispatch := func(update tgbotapi.Update) {
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			handleUpdate(bot, update, msgConf, userManager, providers)
+		}()
+	}
+receive:
+	for {
+		select {
+		case <-ctx.Done():
+			break receive
+		case update, ok := <-updates:
+			if !ok {
+				break receive
 			}
-			if update.Message.Chat.IsGroup() || update.Message.Chat.IsSuperGroup() {
-				mentioned := false
-				command := false
-				for _, entity := range update.Message.Entities {
-					if entity.Type == "mention" {
-						// 提取提及的用户名
-						username := update.Message.Text[entity.Offset : entity.Offset+entity.Length]
-						if username == "@"+bot.Self.UserName {
-							mentioned = true
-							break
-						}
-					} else if entity.Type == "bot_command" {
-						// 检查这是不是一个命令
-						command = true
-						break
-					}
-				}
-				if !mentioned && !command {
-					return
-				}
-				// 如果是命令，即使没有提及也处理
+			dispatch(update)
+		}
+	}
+
+	log.Println("Shutting down, waiting for in-flight replies...")
+	if webhookServer != nil {
+		// 关闭后新的回调返回 503，Telegram 会在重启后重新投递
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		webhookServer.Shutdown(shutdownCtx)
+		cancel()
+	} else {
+		bot.StopReceivingUpdates()
+	}
+	// 已经收到但还在 channel 中排队的更新照常处理，Telegram 不会再次投递它们
+drain:
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				break drain
 			}
+			dispatch(update)
+		default:
+			break drain
+		}
+	}
+	timeout := time.Duration(msgConf.ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	if !waitTimeout(inFlight, timeout) {
+		log.Println("Shutdown deadline reached, interrupting remaining streams")
+		gptMessage.InterruptStreams()
+		// 给被中断的回复留出发送最后一次编辑的时间
+		waitTimeout(inFlight, 10*time.Second)
+	}
+	if err := storage.Close(); err != nil {
+		log.Printf("Failed to close storage: %v", err)
+	}
+	log.Println("Shutdown complete")
+}
 
-			if update.Message.IsCommand() {
-				cmd := update.Message.Command()
-				cmdArgs := update.Message.CommandArguments()
-
-				if cmd == "pic" && strings.TrimSpace(cmdArgs) != "" {
-					message.HandleImg(userManager, msgConf, bot, update, openAIClient)
-				} else if cmd == "pic" && strings.TrimSpace(cmdArgs) == "" {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "use the format: /pic 画一只小猫.")
-					bot.Send(msg)
-				} else {
-					message.HandleCommand(bot, update, openAIClient)
+// openKnowledge 加载知识库索引，并在后台增量重建
+func openKnowledge(msgConf conf.Config, providers *provider.Registry) error {
+	indexPath := msgConf.KnowledgeIndex
+	if indexPath == "" {
+		indexPath = "knowledge.json"
+	}
+	embeddingModel := msgConf.EmbeddingModel
+	if embeddingModel == "" {
+		embeddingModel = "text-embedding-ada-002"
+	}
+	embedder := providers.Get(msgConf.EmbeddingProvider)
+	base, err := knowledge.Open(msgConf.KnowledgeDir, indexPath, func(ctx context.Context, input []string) ([][]float32, error) {
+		return embedder.CreateEmbeddings(ctx, input, embeddingModel)
+	})
+	if err != nil {
+		return err
+	}
+	gptMessage.Knowledge = base
+	if msgConf.KnowledgeTopK > 0 {
+		gptMessage.KnowledgeTopK = msgConf.KnowledgeTopK
+	}
+	go func() {
+		if _, _, err := gptMessage.ReindexKnowledge(); err != nil {
+			log.Printf("Failed to index knowledge base: %v", err)
+		}
+	}()
+	return nil
+}
+
+// waitTimeout 等待 wg 结束，超时返回 false
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// handleUpdate 分发单个更新
+func handleUpdate(bot *tgbotapi.BotAPI, update tgbotapi.Update, msgConf conf.Config, userManager *message.UserManager, providers *provider.Registry) {
+	if update.CallbackQuery != nil {
+		message.HandleCallback(bot, update)
+		return
+	}
+	if update.Message == nil {
+		return
+	}
+	if update.Message.Chat.IsGroup() || update.Message.Chat.IsSuperGroup() {
+		mentioned := false
+		command := false
+		// 图片等媒体消息的文字和实体在 Caption 中
+		text, entities := update.Message.Text, update.Message.Entities
+		if text == "" {
+			text, entities = update.Message.Caption, update.Message.CaptionEntities
+		}
+		for _, entity := range entities {
+			if entity.Type == "mention" {
+				// 提取提及的用户名
+				username := text[entity.Offset : entity.Offset+entity.Length]
+				if username == "@"+bot.Self.UserName {
+					mentioned = true
+					break
 				}
-			} else {
-				message.HandleMessage(userManager, msgConf, bot, update, openAIClient)
+			} else if entity.Type == "bot_command" {
+				// 检查这是不是一个命令
+				command = true
+				break
 			}
+		}
+		// /transcribe 之后发送的语音没有提及机器人，也需要处理
+		waitingAudio := (update.Message.Voice != nil || update.Message.Audio != nil) &&
+			variables.UserSettingsMap.Get(update.Message.From.ID+update.Message.Chat.ID).State == variables.StateWaitingForTranscription
+		if !mentioned && !command && !waitingAudio {
+			return
+		}
+		// 如果是命令，即使没有提及也处理
+	}
+
+	if update.Message.IsCommand() {
+		cmd := update.Message.Command()
+		cmdArgs := update.Message.CommandArguments()
 
-		}(update)
+		if cmd == "pic" && strings.TrimSpace(cmdArgs) != "" {
+			message.HandleImg(userManager, msgConf, bot, update, providers)
+		} else if cmd == "pic" && strings.TrimSpace(cmdArgs) == "" {
+			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "use the format: /pic 画一只小猫.")
+			bot.Send(msg)
+		} else if cmd == "transcribe" {
+			message.HandleTranscribe(userManager, msgConf, bot, update, providers)
+		} else if cmd == "search" || cmd == "url" {
+			message.HandleMessage(userManager, msgConf, bot, update, providers)
+		} else {
+			message.HandleCommand(bot, update, providers)
+		}
+	} else {
+		message.HandleMessage(userManager, msgConf, bot, update, providers)
 	}
 }
@@ -0,0 +1,309 @@
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Telegram HTML 只支持少量标签，这里把模型输出的 Markdown 转换为这些标签：
+// b、i、s、code、pre、a、blockquote。其余结构（标题、列表、表格）用文字符号模拟。
+// https://core.telegram.org/bots/api#html-style
+
+var (
+	fenceRe    = regexp.MustCompile("^\\s*(```+|~~~+)\\s*([\\w#+.-]*)")
+	headingRe  = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	bulletRe   = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	orderedRe  = regexp.MustCompile(`^(\s*)(\d+)[.)]\s+(.*)$`)
+	quoteRe    = regexp.MustCompile(`^\s{0,3}>\s?(.*)$`)
+	ruleRe     = regexp.MustCompile(`^\s{0,3}([-*_])(\s*([-*_]))(\s*([-*_]))+\s*$`)
+	tableRowRe = regexp.MustCompile(`^\s*\|.*\|\s*$`)
+)
+
+// ToTelegramHTML 将 Markdown 渲染为 Telegram 支持的 HTML。
+// 流式输出时代码块可能还没有闭合，未闭合的代码块会渲染到文本结尾。
+func ToTelegramHTML(text string) string {
+	lines := strings.Split(text, "\n")
+	var out []string
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if m := fenceRe.FindStringSubmatch(line); m != nil {
+			fence := m[1]
+			var code []string
+			for i++; i < len(lines); i++ {
+				if closesFence(lines[i], fence) {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			out = append(out, renderCode(m[2], strings.Join(code, "\n")))
+			continue
+		}
+
+		if tableRowRe.MatchString(line) {
+			// Telegram 没有表格，用等宽字体保持对齐
+			var rows []string
+			for ; i < len(lines) && tableRowRe.MatchString(lines[i]); i++ {
+				rows = append(rows, lines[i])
+			}
+			i--
+			out = append(out, "<pre>"+html.EscapeString(strings.Join(rows, "\n"))+"</pre>")
+			continue
+		}
+
+		if quoteRe.MatchString(line) {
+			var quoted []string
+			for ; i < len(lines); i++ {
+				m := quoteRe.FindStringSubmatch(lines[i])
+				if m == nil {
+					break
+				}
+				quoted = append(quoted, renderInline(m[1]))
+			}
+			i--
+			out = append(out, "<blockquote>"+strings.Join(quoted, "\n")+"</blockquote>")
+			continue
+		}
+
+		switch {
+		case ruleRe.MatchString(line):
+			out = append(out, "──────────")
+		case headingRe.MatchString(line):
+			out = append(out, "<b>"+renderInline(headingRe.FindStringSubmatch(line)[1])+"</b>")
+		case bulletRe.MatchString(line):
+			m := bulletRe.FindStringSubmatch(line)
+			out = append(out, m[1]+"• "+renderInline(m[2]))
+		case orderedRe.MatchString(line):
+			m := orderedRe.FindStringSubmatch(line)
+			out = append(out, m[1]+m[2]+". "+renderInline(m[3]))
+		default:
+			out = append(out, renderInline(line))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+func renderCode(language, code string) string {
+	if language == "" {
+		return "<pre>" + html.EscapeString(code) + "</pre>"
+	}
+	return `<pre><code class="language-` + html.EscapeString(language) + `">` + html.EscapeString(code) + "</code></pre>"
+}
+
+// inlineSpans 成对出现的行内标记及对应的 HTML 标签，长的标记放在前面
+var inlineSpans = []struct {
+	marker string
+	tag    string
+}{
+	{"**", "b"},
+	{"__", "b"},
+	{"~~", "s"},
+	{"*", "i"},
+	{"_", "i"},
+}
+
+// renderInline 渲染行内格式：行内代码、粗体、斜体、删除线和链接
+func renderInline(text string) string {
+	var sb strings.Builder
+	for i := 0; i < len(text); {
+		rest := text[i:]
+
+		if rest[0] == '`' {
+			ticks := len(rest) - len(strings.TrimLeft(rest, "`"))
+			if end := strings.Index(rest[ticks:], rest[:ticks]); end >= 0 {
+				code := strings.TrimSpace(rest[ticks : ticks+end])
+				sb.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += 2*ticks + end
+				continue
+			}
+		}
+
+		if rest[0] == '[' {
+			if label, link, n, ok := parseLink(rest); ok {
+				if absoluteLink(link) {
+					sb.WriteString(`<a href="` + html.EscapeString(link) + `">` + renderInline(label) + "</a>")
+				} else {
+					// Telegram 拒绝相对链接，整条消息都会发送失败，只保留文字
+					sb.WriteString(renderInline(label))
+				}
+				i += n
+				continue
+			}
+		}
+
+		if rendered, n, ok := renderSpan(text, i); ok {
+			sb.WriteString(rendered)
+			i += n
+			continue
+		}
+
+		if rest[0] == '\\' && len(rest) > 1 && strings.ContainsRune("\\`*_{}[]()#+-.!~>|", rune(rest[1])) {
+			sb.WriteString(html.EscapeString(rest[1:2]))
+			i += 2
+			continue
+		}
+
+		sb.WriteString(html.EscapeString(rest[:1]))
+		i++
+	}
+	return sb.String()
+}
+
+// renderSpan 尝试在 text[i:] 处匹配粗体、斜体或删除线，返回渲染结果和消耗的字节数
+func renderSpan(text string, i int) (string, int, bool) {
+	rest := text[i:]
+	for _, span := range inlineSpans {
+		if !strings.HasPrefix(rest, span.marker) {
+			continue
+		}
+		m := len(span.marker)
+		// 标记后紧跟空白时不是格式，例如 "a * b"
+		if len(rest) <= m || rest[m] == ' ' {
+			continue
+		}
+		// 下划线按 CommonMark 的 flanking 规则判断，单词中间的下划线不是格式
+		if span.marker[0] == '_' && !canOpenUnderscore(text, i) {
+			continue
+		}
+		end := findClosing(rest[m:], span.marker)
+		if end < 0 {
+			continue
+		}
+		inner := rest[m : m+end]
+		if span.marker[0] == '_' {
+			// Python 的 __init__ 这类名字按原样显示
+			if !canCloseUnderscore(text, i+m+end) || m == 2 && identifierRe.MatchString(inner) {
+				continue
+			}
+		}
+		return "<" + span.tag + ">" + renderInline(inner) + "</" + span.tag + ">", 2*m + end, true
+	}
+	return "", 0, false
+}
+
+// findClosing 查找闭合标记的位置，闭合标记前不能是空白
+func findClosing(text, marker string) int {
+	for offset := 0; offset < len(text); {
+		idx := strings.Index(text[offset:], marker)
+		if idx < 0 {
+			return -1
+		}
+		pos := offset + idx
+		// 单个 * 不能匹配到 ** 的一半
+		if len(marker) == 1 && pos+1 < len(text) && text[pos+1] == marker[0] {
+			offset = pos + 2
+			continue
+		}
+		if pos > 0 && text[pos-1] != ' ' {
+			return pos
+		}
+		offset = pos + len(marker)
+	}
+	return -1
+}
+
+// parseLink 解析 [label](url)，返回 label、url 和消耗的字节数
+func parseLink(text string) (string, string, int, bool) {
+	closeLabel := strings.Index(text, "](")
+	if closeLabel < 0 {
+		return "", "", 0, false
+	}
+	closeURL := linkDestinationEnd(text[closeLabel+2:])
+	if closeURL < 0 {
+		return "", "", 0, false
+	}
+	label := text[1:closeLabel]
+	link := strings.TrimSpace(text[closeLabel+2 : closeLabel+2+closeURL])
+	if label == "" || link == "" || strings.ContainsAny(link, " \n") {
+		return "", "", 0, false
+	}
+	return label, link, closeLabel + 2 + closeURL + 1, true
+}
+
+// linkDestinationEnd 返回链接地址结尾的 ) 的位置，与 CommonMark 一样允许地址中出现成对的括号，
+// 例如 https://en.wikipedia.org/wiki/Go_(language)，反斜杠转义的括号不计入。找不到时返回 -1
+func linkDestinationEnd(text string) int {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case '\n':
+			return -1
+		}
+	}
+	return -1
+}
+
+var identifierRe = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// absoluteLink Telegram 只接受带协议的链接
+func absoluteLink(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "tg", "mailto":
+		return true
+	}
+	return false
+}
+
+// underscoreRun 返回 pos 处下划线串前后的字符，开头或结尾用空格表示
+func underscoreRun(text string, pos int) (before, after rune) {
+	start, end := pos, pos
+	for start > 0 && text[start-1] == '_' {
+		start--
+	}
+	for end < len(text) && text[end] == '_' {
+		end++
+	}
+	before, after = ' ', ' '
+	if start > 0 {
+		before, _ = utf8.DecodeLastRuneInString(text[:start])
+	}
+	if end < len(text) {
+		after, _ = utf8.DecodeRuneInString(text[end:])
+	}
+	return before, after
+}
+
+func isPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// flanking 按 CommonMark 判断下划线串是否 left-flanking、right-flanking
+func flanking(before, after rune) (left, right bool) {
+	left = !unicode.IsSpace(after) && (!isPunct(after) || unicode.IsSpace(before) || isPunct(before))
+	right = !unicode.IsSpace(before) && (!isPunct(before) || unicode.IsSpace(after) || isPunct(after))
+	return left, right
+}
+
+// canOpenUnderscore pos 处的下划线能否开始强调：left-flanking，且不是 right-flanking 或前面是标点
+func canOpenUnderscore(text string, pos int) bool {
+	before, after := underscoreRun(text, pos)
+	left, right := flanking(before, after)
+	return left && (!right || isPunct(before))
+}
+
+// canCloseUnderscore pos 处的下划线能否结束强调：right-flanking，且不是 left-flanking 或后面是标点
+func canCloseUnderscore(text string, pos int) bool {
+	before, after := underscoreRun(text, pos)
+	left, right := flanking(before, after)
+	return right && (!left || isPunct(after))
+}
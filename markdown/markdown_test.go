@@ -0,0 +1,52 @@
+package markdown
+
+import "testing"
+
+func TestToTelegramHTMLLinks(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "plain link",
+			text: "[Go](https://go.dev)",
+			want: `<a href="https://go.dev">Go</a>`,
+		},
+		{
+			name: "balanced parentheses in destination",
+			text: "[x](https://en.wikipedia.org/wiki/Go_(language))",
+			want: `<a href="https://en.wikipedia.org/wiki/Go_(language)">x</a>`,
+		},
+		{
+			name: "link inside parentheses",
+			text: "见 ([x](https://en.wikipedia.org/wiki/Go_(language)))",
+			want: `见 (<a href="https://en.wikipedia.org/wiki/Go_(language)">x</a>)`,
+		},
+		{
+			name: "nested parentheses",
+			text: "[x](https://example.com/a_(b_(c)))",
+			want: `<a href="https://example.com/a_(b_(c))">x</a>`,
+		},
+		{
+			name: "unbalanced parenthesis is not a link",
+			text: "[x](https://example.com/a_(b",
+			want: "[x](https://example.com/a_(b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToTelegramHTML(tt.text); got != tt.want {
+				t.Fatalf("ToTelegramHTML(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToTelegramHTMLLongerFence(t *testing.T) {
+	text := "````md\n```go\nx := 1\n```\n````\nafter"
+	want := "<pre><code class=\"language-md\">```go\nx := 1\n```</code></pre>\nafter"
+	if got := ToTelegramHTML(text); got != want {
+		t.Fatalf("ToTelegramHTML(%q) = %q, want %q", text, got, want)
+	}
+}
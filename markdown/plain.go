@@ -0,0 +1,13 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+)
+
+var tagRe = regexp.MustCompile(`<[^>]+>`)
+
+// PlainText 去掉 Markdown 标记，只保留可朗读的文字，用于语音合成
+func PlainText(text string) string {
+	return html.UnescapeString(tagRe.ReplaceAllString(ToTelegramHTML(text), ""))
+}
@@ -0,0 +1,181 @@
+package markdown
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// UTF16Len 返回文本的 UTF-16 长度，Telegram 的消息长度限制按 UTF-16 code unit 计算
+func UTF16Len(text string) int {
+	n := 0
+	for _, r := range text {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// Split 将 Markdown 文本切分成若干段，每段的 UTF-16 长度不超过 limit。
+// 优先在段落（空行）处切分，其次在行尾切分；不得不在代码块中间切分时，
+// 在前一段末尾补上结束标记，并在下一段开头重新打开同一种代码块。
+// 这里按 Markdown 原文计算长度，发送渲染后的内容时使用 SplitRendered。
+func Split(text string, limit int) []string {
+	var parts []string
+	var current []string
+	currentLen := 0
+	fence := ""     // 当前未闭合代码块的起始行
+	lastBreak := -1 // current 中最后一个位于代码块外的空行
+
+	recount := func() {
+		currentLen = UTF16Len(strings.Join(current, "\n"))
+	}
+	closing := func() int {
+		if fence == "" {
+			return 0
+		}
+		return 1 + UTF16Len(fenceRun(fence))
+	}
+	flush := func() {
+		part := strings.Join(current, "\n")
+		if fence != "" {
+			part += "\n" + fenceRun(fence)
+		}
+		parts = append(parts, part)
+		current = nil
+		if fence != "" {
+			current = []string{fence}
+		}
+		lastBreak = -1
+		recount()
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		for _, piece := range splitLongLine(line, limit-closing()-UTF16Len(fence)-1) {
+			// 本行就是结束标记时不需要再补一个
+			reserve := closing()
+			if fence != "" && closesFence(piece, fence) {
+				reserve = 0
+			}
+			for len(current) > 0 && currentLen+1+UTF16Len(piece)+reserve > limit {
+				if fence == "" && lastBreak > 0 {
+					parts = append(parts, strings.Join(current[:lastBreak], "\n"))
+					current = append([]string(nil), current[lastBreak+1:]...)
+					lastBreak = -1
+					recount()
+					continue
+				}
+				flush()
+			}
+
+			if len(current) == 0 {
+				currentLen = UTF16Len(piece)
+			} else {
+				currentLen += 1 + UTF16Len(piece)
+			}
+			current = append(current, piece)
+
+			if fence == "" && fenceRun(piece) != "" {
+				fence = strings.TrimSpace(piece)
+			} else if fence != "" {
+				if closesFence(piece, fence) {
+					fence = ""
+				}
+			} else if strings.TrimSpace(piece) == "" {
+				lastBreak = len(current) - 1
+			}
+		}
+	}
+	if len(current) > 0 {
+		part := strings.Join(current, "\n")
+		if strings.TrimSpace(part) != "" || len(parts) == 0 {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// fenceRun 返回代码块起始行开头的 ` 或 ~ 串（至少三个），不是代码块边界时返回空
+func fenceRun(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || (line[0] != '`' && line[0] != '~') {
+		return ""
+	}
+	n := 1
+	for n < len(line) && line[n] == line[0] {
+		n++
+	}
+	if n < 3 {
+		return ""
+	}
+	return line[:n]
+}
+
+// closesFence 判断 line 是否结束以 opening 开始的代码块：与起始标记字符相同、长度不短于它，且没有其他内容。
+// 因此 ```` 代码块中的 ```go 示例不会提前结束代码块
+func closesFence(line, opening string) bool {
+	run := fenceRun(opening)
+	line = strings.TrimSpace(line)
+	return run != "" && len(line) >= len(run) && strings.Trim(line, run[:1]) == ""
+}
+
+// minRenderedLimit SplitRendered 缩小 limit 重新切分时的下限
+const minRenderedLimit = 64
+
+// SplitRendered 与 Split 相同，但保证每段经过 render 之后的 UTF-16 长度也不超过 limit：
+// 渲染后超长的段落按膨胀比例缩小 limit 重新切分。未渲染的原文同样不超过 limit，可以作为纯文本回退发送
+func SplitRendered(text string, limit int, render func(string) string) []string {
+	var parts []string
+	for _, part := range Split(text, limit) {
+		parts = append(parts, fitRendered(part, limit, render)...)
+	}
+	return parts
+}
+
+func fitRendered(part string, limit int, render func(string) string) []string {
+	rendered := UTF16Len(render(part))
+	if rendered <= limit {
+		return []string{part}
+	}
+	// 按渲染后的膨胀比例缩小，再留出一成余量
+	smaller := UTF16Len(part) * limit / rendered * 9 / 10
+	if smaller < minRenderedLimit {
+		return []string{part}
+	}
+	pieces := Split(part, smaller)
+	if len(pieces) == 1 {
+		return pieces
+	}
+	var parts []string
+	for _, piece := range pieces {
+		parts = append(parts, fitRendered(piece, limit, render)...)
+	}
+	return parts
+}
+
+// splitLongLine 把超过 limit 的单行切成多段，尽量在空白处断开
+func splitLongLine(line string, limit int) []string {
+	if limit <= 0 || UTF16Len(line) <= limit {
+		return []string{line}
+	}
+	var pieces []string
+	for UTF16Len(line) > limit {
+		cut, n := 0, 0
+		lastSpace := -1
+		for i, r := range line {
+			if n+utf16.RuneLen(r) > limit {
+				break
+			}
+			n += utf16.RuneLen(r)
+			cut = i + utf8.RuneLen(r)
+			if r == ' ' {
+				lastSpace = cut
+			}
+		}
+		if lastSpace > cut/2 {
+			cut = lastSpace
+		}
+		pieces = append(pieces, line[:cut])
+		line = line[cut:]
+	}
+	return append(pieces, line)
+}
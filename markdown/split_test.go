@@ -0,0 +1,173 @@
+package markdown
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestUTF16Len(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"你好", 2},
+		{"😀", 2},
+		{"a😀b", 4},
+	}
+	for _, tt := range tests {
+		if got := UTF16Len(tt.text); got != tt.want {
+			t.Errorf("UTF16Len(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "fits in one part",
+			text:  "hello\nworld",
+			limit: 20,
+			want:  []string{"hello\nworld"},
+		},
+		{
+			name:  "prefers paragraph boundary",
+			text:  "aaa\nbbb\n\nccc\nddd",
+			limit: 12,
+			want:  []string{"aaa\nbbb", "ccc\nddd"},
+		},
+		{
+			name:  "falls back to line boundary",
+			text:  "aaaa\nbbbb\ncccc",
+			limit: 10,
+			want:  []string{"aaaa\nbbbb", "cccc"},
+		},
+		{
+			name:  "emoji counted as two units",
+			text:  "😀😀😀",
+			limit: 5,
+			want:  []string{"😀😀", "😀"},
+		},
+		{
+			name:  "surrogate pair not split at boundary",
+			text:  "ab😀",
+			limit: 3,
+			want:  []string{"ab", "😀"},
+		},
+		{
+			name:  "fence reopened in every part",
+			text:  "```go\nline1\nline2\nline3\nline4\n```",
+			limit: 22,
+			want: []string{
+				"```go\nline1\nline2\n```",
+				"```go\nline3\nline4\n```",
+			},
+		},
+		{
+			name:  "over-long line split at spaces",
+			text:  "alpha beta gamma delta",
+			limit: 12,
+			want:  []string{"alpha beta ", "gamma delta"},
+		},
+		{
+			name:  "over-long line without spaces",
+			text:  "abcdefghij",
+			limit: 4,
+			want:  []string{"abc", "def", "ghi", "j"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Split(tt.text, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Split(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+			checkParts(t, got, tt.limit)
+		})
+	}
+}
+
+func TestSplitLongFence(t *testing.T) {
+	var lines []string
+	for i := 0; i < 50; i++ {
+		lines = append(lines, "fmt.Println(\"第"+strings.Repeat("😀", i%4)+"行\")")
+	}
+	text := "说明\n\n```go\n" + strings.Join(lines, "\n") + "\n```\n\n结尾"
+	parts := Split(text, 120)
+	if len(parts) < 3 {
+		t.Fatalf("expected the fence to span several parts, got %d", len(parts))
+	}
+	checkParts(t, parts, 120)
+
+	// 去掉补上的结束和重新打开的标记后，内容与原文一致
+	var code []string
+	for _, part := range parts {
+		for _, line := range strings.Split(part, "\n") {
+			if strings.HasPrefix(line, "fmt.") {
+				code = append(code, line)
+			}
+		}
+	}
+	if !reflect.DeepEqual(code, lines) {
+		t.Fatalf("code lines changed after split")
+	}
+}
+
+func TestSplitLongerFence(t *testing.T) {
+	text := "````md\n```go\nfmt.Println(1)\n```\nline1\nline2\n````\n\nafter"
+	want := []string{
+		"````md\n```go\nfmt.Println(1)\n```\n````",
+		"````md\nline1\nline2\n````\n\nafter",
+	}
+	got := Split(text, 40)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Split = %q, want %q", got, want)
+	}
+	for i, part := range got {
+		if strings.Count(part, "````")%2 != 0 {
+			t.Errorf("part %d has an unclosed fence: %q", i, part)
+		}
+	}
+}
+
+func TestSplitRendered(t *testing.T) {
+	// 每个 & 渲染后变成 &amp;，按原文切分会超出限制
+	text := strings.Repeat("a & b & c & d\n", 40)
+	limit := 200
+	if n := UTF16Len(ToTelegramHTML(Split(text, limit)[0])); n <= limit {
+		t.Fatalf("test text does not expand enough when rendered: %d", n)
+	}
+	parts := SplitRendered(text, limit, ToTelegramHTML)
+	for i, part := range parts {
+		if n := UTF16Len(ToTelegramHTML(part)); n > limit {
+			t.Errorf("part %d renders to %d UTF-16 units, limit %d", i, n, limit)
+		}
+	}
+	if got := strings.Join(parts, "\n"); strings.Count(got, "&") != strings.Count(text, "&") {
+		t.Fatalf("content lost after split")
+	}
+}
+
+// checkParts 检查每段都不超过 limit、是合法的 UTF-8，且代码块都已闭合
+func checkParts(t *testing.T, parts []string, limit int) {
+	t.Helper()
+	for i, part := range parts {
+		if n := UTF16Len(part); n > limit {
+			t.Errorf("part %d has %d UTF-16 units, limit %d", i, n, limit)
+		}
+		if !utf8.ValidString(part) {
+			t.Errorf("part %d is not valid UTF-8: %q", i, part)
+		}
+		if strings.Count(part, "```")%2 != 0 {
+			t.Errorf("part %d has an unclosed fence: %q", i, part)
+		}
+	}
+}
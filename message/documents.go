@@ -0,0 +1,128 @@
+package message
+
+import (
+	"duolaGPT/docs"
+	"duolaGPT/store"
+	"duolaGPT/variables"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// documentChunkSize 文档切分的块大小（字符）
+	documentChunkSize = 1500
+	// maxDocumentChunks 单个文档最多保留的块数，超出部分丢弃
+	maxDocumentChunks = 400
+)
+
+// attachDocument 下载并提取消息中的文档，切分后保存到会话，成功时返回保存的文档
+func attachDocument(bot *tgbotapi.BotAPI, chatID int64, document *tgbotapi.Document) (store.Document, error) {
+	if !docs.Supported(document.FileName) {
+		return store.Document{}, docs.ErrUnsupported
+	}
+	if document.FileSize > maxDownloadSize {
+		return store.Document{}, fmt.Errorf("document %s is larger than %d bytes", document.FileName, maxDownloadSize)
+	}
+	data, err := downloadFile(bot, document.FileID)
+	if err != nil {
+		return store.Document{}, err
+	}
+	text, err := docs.Extract(document.FileName, data)
+	if err != nil {
+		return store.Document{}, err
+	}
+	chunks := docs.Chunk(text, documentChunkSize)
+	if len(chunks) == 0 {
+		return store.Document{}, fmt.Errorf("no text found in %s", document.FileName)
+	}
+	if len(chunks) > maxDocumentChunks {
+		log.Printf("Document %s has %d chunks, keeping the first %d", document.FileName, len(chunks), maxDocumentChunks)
+		chunks = chunks[:maxDocumentChunks]
+	}
+
+	attached := store.Document{Name: document.FileName, Chunks: chunks, AddedAt: time.Now()}
+	err = variables.Documents.Update(chatID, func(documents []store.Document) []store.Document {
+		// 同名文档视为新版本，替换旧的
+		for i, existing := range documents {
+			if existing.Name == attached.Name {
+				documents[i] = attached
+				return documents
+			}
+		}
+		return append(documents, attached)
+	})
+	return attached, err
+}
+
+// reportAttachError 告诉用户文档没能添加的原因
+func reportAttachError(bot *tgbotapi.BotAPI, chatID int64, document *tgbotapi.Document, err error) {
+	log.Printf("Failed to attach document %s: %v", document.FileName, err)
+	text := fmt.Sprintf("文档 %s 读取失败, 请重试.", document.FileName)
+	if err == docs.ErrUnsupported {
+		text = "暂不支持该文件类型, 支持 .txt .md .pdf .docx 和常见的源代码文件."
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, text))
+}
+
+// handleDocsCommand 处理 /docs 命令：无参数时列出文档，drop <序号|文件名> 删除一个，clear 删除全部
+func handleDocsCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, chatID int64, arg string) {
+	fields := strings.Fields(arg)
+	var text string
+	switch {
+	case len(fields) == 0:
+		text = documentList(chatID)
+	case fields[0] == "clear":
+		if err := variables.Documents.Update(chatID, func([]store.Document) []store.Document { return nil }); err != nil {
+			log.Printf("Failed to clear documents for %d: %v", chatID, err)
+			text = "删除失败, 请重试."
+		} else {
+			text = "已删除全部文档."
+		}
+	case fields[0] == "drop" && len(fields) > 1:
+		target := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(arg), "drop"))
+		dropped := ""
+		err := variables.Documents.Update(chatID, func(documents []store.Document) []store.Document {
+			for i, document := range documents {
+				if document.Name == target || strconv.Itoa(i+1) == target {
+					dropped = document.Name
+					return append(documents[:i], documents[i+1:]...)
+				}
+			}
+			return documents
+		})
+		switch {
+		case err != nil:
+			log.Printf("Failed to drop document for %d: %v", chatID, err)
+			text = "删除失败, 请重试."
+		case dropped == "":
+			text = fmt.Sprintf("没有找到文档: %s", target)
+		default:
+			text = fmt.Sprintf("已删除文档: %s", dropped)
+		}
+	default:
+		text = "用法: /docs 查看文档, /docs drop <序号|文件名> 删除文档, /docs clear 删除全部文档"
+	}
+	bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+}
+
+func documentList(chatID int64) string {
+	documents, err := variables.Documents.Get(chatID)
+	if err != nil {
+		log.Printf("Failed to load documents for %d: %v", chatID, err)
+		return "读取文档列表失败, 请重试."
+	}
+	if len(documents) == 0 {
+		return "当前会话没有文档, 直接发送文件即可添加."
+	}
+	var builder strings.Builder
+	builder.WriteString("当前会话的文档:\n")
+	for i, document := range documents {
+		fmt.Fprintf(&builder, "%d. %s (%d 段, %s 添加)\n", i+1, document.Name, len(document.Chunks), document.AddedAt.Format("2006-01-02 15:04"))
+	}
+	builder.WriteString("\n/docs drop <序号|文件名> 删除文档, /docs clear 删除全部")
+	return builder.String()
+}
@@ -0,0 +1,61 @@
+package message
+
+import (
+	"encoding/base64"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"io"
+	"net/http"
+)
+
+// maxDownloadSize Bot API 允许下载的文件最大为 20MB
+const maxDownloadSize = 20 << 20
+
+// downloadFile 通过 Bot API 下载用户发送的文件
+func downloadFile(bot *tgbotapi.BotAPI, fileID string) ([]byte, error) {
+	fileURL, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := bot.Client.Do(req)
+	if err != nil {
+		// 错误信息中带有包含 bot token 的 URL，不直接返回
+		return nil, fmt.Errorf("download file %s failed", fileID)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download file %s failed with status %s", fileID, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDownloadSize {
+		return nil, fmt.Errorf("file %s is larger than %d bytes", fileID, maxDownloadSize)
+	}
+	return data, nil
+}
+
+// largestPhoto 返回同一张图片的多个尺寸中最大的一个
+func largestPhoto(photos []tgbotapi.PhotoSize) tgbotapi.PhotoSize {
+	largest := photos[0]
+	for _, photo := range photos[1:] {
+		if photo.Width*photo.Height > largest.Width*largest.Height {
+			largest = photo
+		}
+	}
+	return largest
+}
+
+// downloadPhotoDataURL 下载消息中最大尺寸的图片，返回可直接发给模型的 data URL
+func downloadPhotoDataURL(bot *tgbotapi.BotAPI, photos []tgbotapi.PhotoSize) (string, error) {
+	data, err := downloadFile(bot, largestPhoto(photos).FileID)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + http.DetectContentType(data) + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,72 @@
+package message
+
+import (
+	"duolaGPT/gptMessage"
+	"duolaGPT/knowledge"
+	"duolaGPT/utils"
+	"duolaGPT/variables"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"log"
+	"strings"
+)
+
+// handleKnowledgeCommand 处理 /kb on|off|reindex，无参数时显示知识库状态
+func handleKnowledgeCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, chatID int64, arg string) {
+	if gptMessage.Knowledge == nil {
+		bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "未配置知识库 (knowledge_dir)."))
+		return
+	}
+
+	var text string
+	switch strings.ToLower(strings.TrimSpace(arg)) {
+	case "on":
+		variables.UserSettingsMap.Update(chatID, func(user *variables.User) {
+			user.KnowledgeDisabled = false
+		})
+		text = "已开启知识库检索."
+	case "off":
+		variables.UserSettingsMap.Update(chatID, func(user *variables.User) {
+			user.KnowledgeDisabled = true
+		})
+		text = "已关闭知识库检索."
+	case "reindex":
+		// 重建索引需要调用 embedding 接口，只允许白名单用户执行
+		if !utils.StringInSlice(AllowedUsers, update.Message.From.UserName) {
+			text = "只有白名单用户可以重建知识库索引."
+			break
+		}
+		text = "正在后台重建知识库索引, 完成后通知."
+		chat := update.Message.Chat.ID
+		gptMessage.InFlight.Add(1)
+		go func() {
+			defer gptMessage.InFlight.Done()
+			bot.Send(tgbotapi.NewMessage(chat, reindexResult()))
+		}()
+	case "":
+		state := "开启"
+		if variables.UserSettingsMap.Get(chatID).KnowledgeDisabled {
+			state = "关闭"
+		}
+		files, passages := gptMessage.Knowledge.Size()
+		text = fmt.Sprintf("知识库检索: %s\n已索引 %d 个文件, %d 段.\n/kb on|off 开启或关闭, /kb reindex 重建索引", state, files, passages)
+	default:
+		text = "用法: /kb on|off|reindex"
+	}
+	bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+}
+
+// reindexResult 重建知识库索引并返回给用户的结果
+func reindexResult() string {
+	updated, removed, err := gptMessage.ReindexKnowledge()
+	switch {
+	case errors.Is(err, knowledge.ErrIndexing):
+		return "知识库索引正在重建中, 请稍后再试."
+	case err != nil:
+		log.Printf("Failed to reindex knowledge base: %v", err)
+		return "重建索引失败, 请查看日志."
+	}
+	files, passages := gptMessage.Knowledge.Size()
+	return fmt.Sprintf("索引已更新: 新增或修改 %d 个文件, 移除 %d 个文件, 共 %d 个文件 %d 段.", updated, removed, files, passages)
+}
@@ -3,8 +3,14 @@ package message
 import (
 	"duolaGPT/conf"
 	"duolaGPT/gptMessage"
+	"duolaGPT/markdown"
+	"duolaGPT/models"
+	"duolaGPT/provider"
+	"duolaGPT/store"
+	"duolaGPT/tools"
 	"duolaGPT/utils"
 	"duolaGPT/variables"
+	"errors"
 	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/sashabaranov/go-openai"
@@ -17,30 +23,32 @@ import (
 var mu = &sync.Mutex{}
 var FreeChatCount int
 
-// UserManager 管理用户状态和消息计数
+// AllowedUsers 白名单用户名，main 中按 allowed_telegram_usernames 设置，用于限制 /kb reindex 等管理操作
+var AllowedUsers []string
+
+// messageLimit Telegram 单条消息的长度上限（UTF-16），按渲染后的 HTML 计算，见 markdown.SplitRendered
+const messageLimit = 4096
+
+// interruptedNote 服务关闭时追加在被中断回复的末尾
+const interruptedNote = "\n\n⚠️ 服务重启, 回复已中断 (interrupted by restart)"
+
+// UserManager 管理用户的免费对话配额
 type UserManager struct {
-	mu    sync.Mutex
-	users map[int]*variables.User
+	quota store.QuotaStore
 }
 
 // NewUserManager 创建UserManager的新实例
-func NewUserManager() *UserManager {
+func NewUserManager(quota store.QuotaStore) *UserManager {
 	return &UserManager{
-		users: make(map[int]*variables.User),
+		quota: quota,
 	}
 }
 
-func (manager *UserManager) IncrementMessageCount(userID int) int {
-	manager.mu.Lock()
-	defer manager.mu.Unlock()
-
-	user, exists := manager.users[userID]
-	if !exists {
-		user = &variables.User{} // 如果用户不存在，则创建一个新用户
-		manager.users[userID] = user
-	}
-	user.MessageCount++
-	return user.MessageCount
+// IncrementMessageCount 在当前配额窗口内为用户计数加一，返回计数和下一次重置时间
+func (manager *UserManager) IncrementMessageCount(userID int64, reset string) (int, time.Time, error) {
+	windowStart, nextReset := quotaWindow(reset, time.Now())
+	count, err := manager.quota.Increment(userID, windowStart)
+	return count, nextReset, err
 }
 
 func (manager *UserManager) CheckUserAccess(config conf.Config, update tgbotapi.Update, bot *tgbotapi.BotAPI) bool {
@@ -53,11 +61,20 @@ func (manager *UserManager) CheckUserAccess(config conf.Config, update tgbotapi.
 	}
 
 	// 如果用户不在白名单中，增加他们的消息计数。update.Message.From.ID 保证私聊和群组使用都被统计
-	count := manager.IncrementMessageCount(int(userID))
+	count, nextReset, err := manager.IncrementMessageCount(userID, config.FreeChatReset)
+	if err != nil {
+		log.Printf("Failed to update quota for %d: %v", userID, err)
+		bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "暂时无法校验对话次数，请稍后再试."))
+		return false
+	}
 
 	// 如果用户的消息计数超过FreeChatCount，通知用户并返回false。
 	if count > FreeChatCount {
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "体验对话次数已用尽.")
+		text := "体验对话次数已用尽."
+		if !nextReset.IsZero() {
+			text = fmt.Sprintf("体验对话次数已用尽，将于 %s 重置.", nextReset.Format("2006-01-02 15:04"))
+		}
+		msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
 		bot.Send(msg)
 		return false
 	}
@@ -67,12 +84,69 @@ func (manager *UserManager) CheckUserAccess(config conf.Config, update tgbotapi.
 	return true
 }
 
-func HandleMessage(userManager *UserManager, config conf.Config, bot *tgbotapi.BotAPI, update tgbotapi.Update, client *openai.Client) {
+func HandleMessage(userManager *UserManager, config conf.Config, bot *tgbotapi.BotAPI, update tgbotapi.Update, providers *provider.Registry) {
+
+	chatID := update.Message.From.ID + update.Message.Chat.ID
+	user := variables.UserSettingsMap.Get(chatID)
+	model := models.Resolve(user.Model)
+
+	// /search 和 /url 的参数作为问题
+	inputText := update.Message.Text
+	command := update.Message.Command()
+	if command != "" {
+		inputText = update.Message.CommandArguments()
+		if usage := searchUsage(command, inputText); usage != "" {
+			bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, usage))
+			return
+		}
+	}
+	// 图片消息的文字在 Caption 中
+	if len(update.Message.Photo) > 0 {
+		if !model.Vision {
+			bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, visionHint(model)))
+			return
+		}
+		inputText = update.Message.Caption
+		if strings.TrimSpace(inputText) == "" {
+			inputText = "请描述这张图片."
+		}
+	}
 
 	if !userManager.CheckUserAccess(config, update, bot) {
 		return // 如果用户没有访问权限，则直接返回。
 	}
 
+	// 语音和音频先转写成文字，再按普通消息处理
+	if _, _, _, ok := audioFile(update.Message); ok {
+		text, ok := transcribeOrReport(bot, providers, update.Message)
+		if !ok {
+			return
+		}
+		if user.State == variables.StateWaitingForTranscription {
+			variables.UserSettingsMap.Update(chatID, func(user *variables.User) {
+				user.State = variables.StateDefault
+			})
+			replyTranscript(bot, update.Message, text, false)
+			return
+		}
+		replyTranscript(bot, update.Message, text, true)
+		inputText = text
+	}
+
+	// 文档加入会话，附带说明文字时把它作为问题继续对话
+	if document := update.Message.Document; document != nil {
+		attached, err := attachDocument(bot, chatID, document)
+		if err != nil {
+			reportAttachError(bot, update.Message.Chat.ID, document, err)
+			return
+		}
+		inputText = update.Message.Caption
+		if strings.TrimSpace(inputText) == "" {
+			bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("已添加文档 %s (%d 段), 现在可以针对它提问了. /docs 查看文档", attached.Name, len(attached.Chunks))))
+			return
+		}
+	}
+
 	currentTime := time.Now()
 	currentDateString := currentTime.Format("2006-01-02")
 	// 获取当前是周几
@@ -91,235 +165,152 @@ func HandleMessage(userManager *UserManager, config conf.Config, bot *tgbotapi.B
 
 	// 获取当前星期几的中文表示
 	currentWeekdayChinese := weekdaysChinese[currentWeekday]
-	language := "Chinese"
 
-	mu.Lock()
-
-	user := variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID]
-	state := user.State
-	model := user.Model
-	if model == "" {
-		model = variables.DefaultModel
-	}
-	variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID] = variables.User{
-		Model: model,
-		State: state,
-	}
-
-	mu.Unlock()
-	if state == variables.StateWaitingForSystemPrompt {
-		mu.Lock()
-		variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID] = variables.User{
-			Model:        model,
-			SystemPrompt: update.Message.Text + fmt.Sprintf(" Respond conversationally in %s. Knowledge cutoff: 2023-04. 当前时间:  %s ", language, currentDateString+" "+currentWeekdayChinese),
-			State:        variables.StateDefault,
-		}
-		mu.Unlock()
+	if user.State == variables.StateWaitingForSystemPrompt {
+		variables.UserSettingsMap.Update(chatID, func(user *variables.User) {
+			user.SystemPrompt = inputText + fmt.Sprintf(" Respond conversationally in %s. Knowledge cutoff: 2023-04. 当前时间:  %s ", variables.LanguagePlaceholder, currentDateString+" "+currentWeekdayChinese)
+			user.State = variables.StateDefault
+		})
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "System prompt set.")
 		bot.Send(msg)
 		return
 	}
 	var err error
-	stringText := update.Message.Text
-
-	if utils.CheckForKeywords(update.Message.Text, config) {
-
-		searchQuery := update.Message.Text
-
-		// 用于存储累积的文本
-		var stringTextBuilder strings.Builder
-
-		// 定义搜索的起始位置
-		startIndex := 1
-
-		// 循环直到文本长度达到16000个字符或者没有更多的搜索结果,锁定promptTokens
-		for stringTextBuilder.Len() < 10000 {
-			searchResult, err := utils.PerformGoogleSearch(config.GoogleSearchKey, config.GoogleSearchEngineID, searchQuery, startIndex, 10, "lang_zh-CN", config.ProxyUrl)
-			if err != nil {
-				log.Printf("Failed to search from google: %v", err)
-				break
-			}
-			// 如果没有搜索结果，跳出循环
-			if len(searchResult.Items) == 0 {
-				break
-			}
-
-			// 提取搜索结果的摘要
-			stringTextGoogle := utils.ExtractSummariesFromSearchResult(searchResult, config.ProxyUrl)
-
-			// 追加到累积的文本中
-			for _, summary := range stringTextGoogle {
-				stringTextBuilder.WriteString(summary + " ")
-				if stringTextBuilder.Len() >= 10000 {
-					break
-				}
-			}
-			// 更新搜索的起始位置以获取下一批结果
-			startIndex += 1
-			// 可以根据需要添加延迟以避免过快的请求导致被搜索引擎限制
-			// time.Sleep(time.Second * 1)
+	stringText := inputText
+
+	ctx, cancel := gptMessage.RequestContext(chatID)
+	defer cancel()
+
+	// /search 总是搜索；自动搜索时支持工具调用的模型自己决定是否搜索，其余模型按关键字触发
+	var sources []utils.SearchSource
+	switch {
+	case command == "url":
+		var ok bool
+		stringText, sources, ok = pagePrompt(ctx, bot, update, inputText)
+		if !ok {
+			return
+		}
+	case command == "search" || !user.SearchDisabled && !gptMessage.ToolEnabled(model, tools.WebSearchName) && utils.CheckForKeywords(inputText, config):
+		sources = utils.SearchSources(ctx, inputText, searchLimit)
+		if ctx.Err() != nil {
+			// 搜索期间收到 /stop
+			return
 		}
-		// 获取累积的文本并截取前10000个字符（如果需要）
-		stringText = stringTextBuilder.String()
-		if len(stringText) > 10000 {
-			stringText = stringText[:10000]
+		if len(sources) > 0 {
+			stringText = utils.SearchPrompt(inputText, sources)
+		} else if command == "search" {
+			bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "没有搜索到结果, 将直接回答."))
 		}
+	}
 
+	var images []string
+	if len(update.Message.Photo) > 0 {
+		image, err := downloadPhotoDataURL(bot, update.Message.Photo)
+		if err != nil {
+			log.Printf("Failed to download photo: %v", err)
+			bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "图片下载失败, 请重试."))
+			return
+		}
+		images = append(images, image)
 	}
 
-	generatedTextStream, err := gptMessage.GenerateTextStreamWithGPT(client, stringText, update.Message.From.ID+update.Message.Chat.ID, model)
+	if len(sources) > 0 {
+		// 预先搜索的来源编号从 1 开始，模型再调用搜索工具时接着编号，回复末尾只附一次来源列表
+		citations := &tools.Citations{}
+		citations.Add(sources...)
+		ctx = tools.WithCitations(ctx, citations)
+	}
+
+	generatedTextStream, err := gptMessage.GenerateTextStreamWithGPT(ctx, providers, stringText, chatID, model, images...)
 	if err != nil {
 		log.Printf("Failed to generate text stream with GPT: %v", err)
+		if errors.Is(err, gptMessage.ErrMessageTooLong) {
+			bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("消息过长, 超出了 %s 的上下文长度, 请缩短后重试或 /model 切换到上下文更长的模型.", model.Alias)))
+		}
 		return
 	}
-	var text string
-	HasGetChangeID := false
-	messageID := 0
-
-	var charThreshold = 200
 	var buffer strings.Builder
+	// messageIDs 与 sentParts 一一对应，记录已发送的每一段及其内容
+	var messageIDs []int
+	var sentParts []string
+
+	// syncParts 把完整回复切分成多条消息，内容有变化的段落编辑原消息，新增的段落发送新消息
+	syncParts := func(text string) error {
+		for i, part := range markdown.SplitRendered(text, messageLimit, markdown.ToTelegramHTML) {
+			if i < len(sentParts) && sentParts[i] == part {
+				continue
+			}
+			messageID := 0
+			if i < len(messageIDs) {
+				messageID = messageIDs[i]
+			}
+			msg, err := sendRendered(bot, update.Message.Chat.ID, messageID, part)
+			if err != nil && !isNotModified(err) {
+				// 保持段落顺序，剩下的部分等下一次同步
+				log.Printf("Failed to send message: %v", err)
+				return err
+			}
+			// 内容没有变化说明这一段已经是最新的
+			if messageID == 0 {
+				messageIDs = append(messageIDs, msg.MessageID)
+				sentParts = append(sentParts, part)
+			} else {
+				sentParts[i] = part
+			}
+		}
+		return nil
+	}
 
+	// 按时间间隔合并编辑，避免触发 Telegram 的 429
+	scheduler := newEditScheduler(chatSendInterval(update.Message.Chat.ID), syncParts)
+	HasGetChangeID := false
 	for generatedText := range generatedTextStream {
 		if HasGetChangeID == false {
 			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "waiting...")
 			msg.ReplyToMessageID = update.Message.MessageID
-			msg_, err := bot.Send(msg)
+			msg_, err := send(bot, update.Message.Chat.ID, msg)
 			if err != nil {
 				log.Printf("Failed to send message: %v", err)
+			} else {
+				messageIDs = append(messageIDs, msg_.MessageID)
+				sentParts = append(sentParts, msg.Text)
 			}
-			messageID = msg_.MessageID
 			HasGetChangeID = true
 		}
 		buffer.WriteString(generatedText)
-
-		if buffer.Len() >= charThreshold {
-			text = buffer.String()
-			if len(text) > 4096 {
-				if isCode(text) {
-					// 使用 Markdown 格式化代码块
-					formattedText := "```" + escapeMarkdownCode(text) + "```"
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, formattedText)
-					msg.ParseMode = tgbotapi.ModeMarkdownV2 // 使用 Markdown V2
-					msg_, err := bot.Send(msg)
-					if err != nil {
-						log.Printf("Failed to send message: %v", err)
-					} else {
-						messageID = msg_.MessageID
-						buffer.Reset()
-					}
-				} else {
-					// 发送普通文本
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
-					msg_, err := bot.Send(msg)
-					if err != nil {
-						log.Printf("Failed to send message: %v", err)
-					} else {
-						messageID = msg_.MessageID
-						buffer.Reset()
-					}
-				}
-
-			} else {
-
-				if buffer.Len() >= charThreshold {
-					if isCode(text) {
-						// 使用 Markdown 格式化代码块
-						formattedText := "```" + escapeMarkdownCode(text) + "```"
-						msg := tgbotapi.NewEditMessageText(update.Message.Chat.ID, messageID, formattedText)
-						msg.ParseMode = tgbotapi.ModeMarkdownV2 // 使用 Markdown V2
-						_, err := bot.Send(msg)
-						if err != nil {
-							log.Printf("Failed to send message: %v", err)
-						}
-						charThreshold += 100
-
-					} else {
-						// 发送普通文本
-						msg := tgbotapi.NewEditMessageText(update.Message.Chat.ID, messageID, text)
-						_, err := bot.Send(msg)
-						if err != nil {
-							log.Printf("Failed to send message: %v", err)
-						}
-						charThreshold += 100
-
-					}
-
-				}
-			}
-		}
+		scheduler.Update(buffer.String())
 	}
-	if buffer.Len() > 0 {
-		text = buffer.String()
-		if len(text) > 4096 {
-			if isCode(text) {
-				// 使用 Markdown 格式化代码块
-				formattedText := "```" + escapeMarkdownCode(text) + "```"
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, formattedText)
-				msg.ParseMode = tgbotapi.ModeMarkdownV2 // 使用 Markdown V2
-				_, err := bot.Send(msg)
-				if err != nil {
-					log.Printf("Failed to send message: %v", err)
-				}
-			} else {
-				// 发送普通文本
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
-				_, err := bot.Send(msg)
-				if err != nil {
-					log.Printf("Failed to send message: %v", err)
-				}
-			}
-
-		} else {
-
-			if isCode(text) {
-				// 使用 Markdown 格式化代码块
-				formattedText := "```" + escapeMarkdownCode(text) + "```"
-				msg := tgbotapi.NewEditMessageText(update.Message.Chat.ID, messageID, formattedText)
-				msg.ParseMode = tgbotapi.ModeMarkdownV2 // 使用 Markdown V2
-				_, err := bot.Send(msg)
-				if err != nil {
-					log.Printf("Failed to send message: %v", err)
-				}
-			} else {
-				// 发送普通文本
-				msg := tgbotapi.NewEditMessageText(update.Message.Chat.ID, messageID, text)
-				_, err := bot.Send(msg)
-				if err != nil {
-					log.Printf("Failed to send message: %v", err)
-				}
-			}
-
-		}
+	interrupted := HasGetChangeID && gptMessage.Interrupted()
+	if interrupted {
+		buffer.WriteString(interruptedNote)
+	}
+	scheduler.Finish(buffer.String())
+	if user.VoiceMode && !interrupted && strings.TrimSpace(buffer.String()) != "" {
+		replyVoice(bot, providers, update.Message, utils.WithoutSourcesFooter(buffer.String()))
 	}
 	fmt.Printf("\n" + "#####################################################" + "\n")
 	fmt.Printf("当前用户:%s-id:%d的对话历史:", update.Message.From.UserName, update.Message.From.ID)
 	var t string
-	for _, message := range variables.ConversationHistory[update.Message.From.ID+update.Message.Chat.ID] {
-		t += message.Content + "-" // 累积对话历史
+	for _, message := range variables.ConversationHistory.Get(chatID) {
+		t += gptMessage.MessageText(message) + "-" // 累积对话历史
 	}
 	fmt.Println(t)
 	fmt.Println("#####################################################")
-	gptMessage.CompleteResponse(update.Message.From.ID + update.Message.Chat.ID)
+	gptMessage.CompleteResponse(chatID)
 }
 
-func HandleImg(userManager *UserManager, config conf.Config, bot *tgbotapi.BotAPI, update tgbotapi.Update, client *openai.Client) {
+func HandleImg(userManager *UserManager, config conf.Config, bot *tgbotapi.BotAPI, update tgbotapi.Update, providers *provider.Registry) {
 	if !userManager.CheckUserAccess(config, update, bot) {
 		return // 如果用户没有访问权限，则直接返回。
 	}
 	ImgArg := update.Message.CommandArguments()
-	model := variables.GPTPICModel
-	mu.Lock()
-	variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID] = variables.User{
-		Model:        variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID].Model,
-		SystemPrompt: ImgArg,
-	}
-	mu.Unlock()
+	model := models.Image()
 	waitingMsg, err := bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "waiting..."))
 	if err != nil {
 		log.Printf("Failed to send waiting message: %v", err)
 		return
 	}
-	generatedImg, err := gptMessage.GenerateImgWithGPT(client, ImgArg, update.Message.Chat.ID, model)
+	generatedImg, err := gptMessage.GenerateImgWithGPT(providers, ImgArg, update.Message.Chat.ID, model)
 	if err != nil {
 		log.Printf("Failed to generate img with GPT: %v", err)
 		deleteConfig := tgbotapi.NewDeleteMessage(update.Message.Chat.ID, waitingMsg.MessageID)
@@ -334,7 +325,7 @@ func HandleImg(userManager *UserManager, config conf.Config, bot *tgbotapi.BotAP
 	bot.Send(generatedImg)
 }
 
-func HandleCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, client *openai.Client) {
+func HandleCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, providers *provider.Registry) {
 
 	currentTime := time.Now()
 	currentDateString := currentTime.Format("2006-01-02")
@@ -354,164 +345,207 @@ func HandleCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, client *openai.
 
 	// 获取当前星期几的中文表示
 	currentWeekdayChinese := weekdaysChinese[currentWeekday]
-	language := "Chinese"
 	command := update.Message.Command()
 	commandArg := update.Message.CommandArguments()
 	// 获取用户ID
 	userID := update.Message.From.ID + update.Message.Chat.ID
 
-	// 检查用户是否已经有一个会话状态
-	if _, exists := variables.UserSettingsMap[userID]; !exists {
-		// 如果没有，则为该用户创建一个新的会话状态
-		user := variables.UserSettingsMap[userID]
-		variables.UserSettingsMap[userID] = user
-	}
-
 	switch command {
 	case "start":
-		mu.Lock()
-		systemPrompt := variables.DefaultSystemPrompt + fmt.Sprintf(" Respond conversationally in %s. Knowledge cutoff: 2023-04. 当前时间: %s ", language, currentDateString+" "+currentWeekdayChinese)
-		variables.ConversationHistory[update.Message.From.ID+update.Message.Chat.ID] = []openai.ChatCompletionMessage{
+		systemPrompt := variables.DefaultSystemPrompt + fmt.Sprintf(" Respond conversationally in %s. Knowledge cutoff: 2023-04. 当前时间: %s ", variables.LanguagePlaceholder, currentDateString+" "+currentWeekdayChinese)
+		variables.ConversationHistory.Set(userID, []openai.ChatCompletionMessage{
 			{
 				Role:    "system",
 				Content: systemPrompt,
 			},
+		})
+		if err := variables.Documents.Update(userID, func([]store.Document) []store.Document { return nil }); err != nil {
+			log.Printf("Failed to clear documents for %d: %v", userID, err)
 		}
-		variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID] = variables.User{
-			Model:        variables.DefaultModel,
-			SystemPrompt: systemPrompt,
-		}
-		mu.Unlock()
+		variables.UserSettingsMap.Update(userID, func(user *variables.User) {
+			user.Model = models.Default().Alias
+			user.SystemPrompt = systemPrompt
+			user.State = variables.StateDefault
+		})
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "欢迎来到哆啦助手!\n"+
 			"/start - 清除 Prompt 和会话记录\n"+
 			"/new - 仅清除会话记录\n"+
-			"/gpt3 - 切换 GPT-3 模型\n"+
-			"/gpt4 - 切换 GPT-4 模型\n"+
+			"/model - 切换模型, 如 /model gpt4\n"+
+			"/models - 查看可用模型\n"+
 			"/pic - 切换图片模型\n"+
 			"/stop - 中止 GPT 输出\n"+
-			"/prompt - 设置 prompt 提示词")
+			"/prompt - 设置 prompt 提示词\n"+
+			"/summary - 开启/关闭长对话自动摘要\n"+
+			"/transcribe - 语音转文字, 回复一条语音或之后发送语音\n"+
+			"/voice - 开启/关闭语音回复\n"+
+			"/docs - 查看/删除已上传的文档\n"+
+			"/kb - 开启/关闭知识库检索, /kb reindex 重建索引\n"+
+			"/search - 联网搜索后回答, 如 /search 今天的新闻\n"+
+			"/url - 阅读网页后回答, 如 /url 链接 问题\n"+
+			"/autosearch - 开启/关闭自动联网搜索\n"+
+			"/settings - 打开设置面板")
 		bot.Send(msg)
 	case "new":
-		mu.Lock()
-		variables.ConversationHistory[update.Message.From.ID+update.Message.Chat.ID] = []openai.ChatCompletionMessage{
+		variables.ConversationHistory.Set(userID, []openai.ChatCompletionMessage{
 			{
 				Role:    "system",
-				Content: variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID].SystemPrompt,
+				Content: variables.UserSettingsMap.Get(userID).SystemPrompt,
 			},
-		}
-		mu.Unlock()
+		})
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "已开启全新会话.")
 		bot.Send(msg)
-	case "gpt4":
-		mu.Lock()
-		variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID] = variables.User{
-			Model: variables.GPT4Model,
+	case "model":
+		if strings.TrimSpace(commandArg) == "" {
+			current := models.Resolve(variables.UserSettingsMap.Get(userID).Model)
+			bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("当前模型: %s (%s). 使用方式: /model 别名, /models 查看可用模型.", current.Alias, current.Name)))
+			return
 		}
-		mu.Unlock()
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "开启gpt-4-1106-preview模型.")
-		bot.Send(msg)
-	case "gpt3":
-		mu.Lock()
-		variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID] = variables.User{
-			Model: variables.GPT35TurboModel,
+		model, ok := models.Lookup(commandArg)
+		if !ok {
+			bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("未知模型: %s, 使用 /models 查看可用模型.", commandArg)))
+			return
 		}
-		mu.Unlock()
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "开启gpt-3.5-turbo模型.")
+		variables.UserSettingsMap.Update(userID, func(user *variables.User) {
+			user.Model = model.Alias
+		})
+		msg := tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("开启%s模型.", model.Name))
 		bot.Send(msg)
-	case "pic":
-		mu.Lock()
-		variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID] = variables.User{
-			Model: variables.GPTPICModel,
+	case "models":
+		current := models.Resolve(variables.UserSettingsMap.Get(userID).Model)
+		var list strings.Builder
+		list.WriteString("可用模型:\n")
+		for _, model := range models.All() {
+			mark := "  "
+			if model.Alias == current.Alias {
+				mark = "✅"
+			}
+			list.WriteString(fmt.Sprintf("%s %s - %s (上下文 %d)\n", mark, model.Alias, model.Name, model.ContextSize))
 		}
-		mu.Unlock()
+		list.WriteString("使用 /model 别名 切换.")
+		bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, list.String()))
+	case "pic":
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "开启绘图. 使用方式: /pic 灰色的天空漫天的乌鸦")
 		bot.Send(msg)
 	case "stop":
 		mu.Lock()
-		user := variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID]
+		user := variables.UserSettingsMap.Get(userID)
 		if user.CurrentContext != nil {
 			(*user.CurrentContext)()
 		}
 
-		gptMessage.CompleteResponse(update.Message.From.ID + update.Message.Chat.ID)
+		gptMessage.CompleteResponse(userID)
 		mu.Unlock()
 	case "prompt":
 		if commandArg == "" {
-			mu.Lock()
-			variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID] = variables.User{
-				Model:        variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID].Model,
-				SystemPrompt: variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID].SystemPrompt,
-				State:        variables.StateWaitingForSystemPrompt,
-			}
-			mu.Unlock()
+			variables.UserSettingsMap.Update(userID, func(user *variables.User) {
+				user.State = variables.StateWaitingForSystemPrompt
+			})
 			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "请输入你想要的prompt.")
 			bot.Send(msg)
 			return
 		}
-		mu.Lock()
-		variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID] = variables.User{
-			Model:        variables.UserSettingsMap[update.Message.From.ID+update.Message.Chat.ID].Model,
-			SystemPrompt: commandArg + fmt.Sprintf("Respond conversationally in %s. Knowledge cutoff: 2023-04. Current date:  %s ", language, currentDateString),
-		}
-		variables.ConversationHistory[update.Message.From.ID+update.Message.Chat.ID] = []openai.ChatCompletionMessage{
+		variables.UserSettingsMap.Update(userID, func(user *variables.User) {
+			user.SystemPrompt = commandArg + fmt.Sprintf(" Respond conversationally in %s. Knowledge cutoff: 2023-04. Current date:  %s ", variables.LanguagePlaceholder, currentDateString)
+			user.State = variables.StateDefault
+		})
+		variables.ConversationHistory.Set(userID, []openai.ChatCompletionMessage{
 			{
 				Role:    "system",
 				Content: commandArg,
 			},
-		}
-		mu.Unlock()
+		})
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("已设置自定义prompt: %s", commandArg))
 		bot.Send(msg)
+	case "settings":
+		HandleSettings(bot, update)
+	case "summary":
+		user := variables.UserSettingsMap.Update(userID, func(user *variables.User) {
+			switch strings.ToLower(strings.TrimSpace(commandArg)) {
+			case "on":
+				user.AutoSummary = true
+			case "off":
+				user.AutoSummary = false
+			default:
+				user.AutoSummary = !user.AutoSummary
+			}
+		})
+		text := "已关闭自动摘要, 超出上下文的早期对话不再发送给模型, 但仍保留在历史中."
+		if user.AutoSummary {
+			text = "已开启自动摘要, 超出上下文的早期对话将被压缩成摘要保留."
+		}
+		bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+	case "autosearch":
+		user := variables.UserSettingsMap.Update(userID, func(user *variables.User) {
+			switch strings.ToLower(strings.TrimSpace(commandArg)) {
+			case "on":
+				user.SearchDisabled = false
+			case "off":
+				user.SearchDisabled = true
+			default:
+				user.SearchDisabled = !user.SearchDisabled
+			}
+		})
+		text := "已开启自动联网搜索."
+		if user.SearchDisabled {
+			text = "已关闭自动联网搜索, 仍可使用 /search 和 /url."
+		}
+		bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+	case "kb":
+		handleKnowledgeCommand(bot, update, userID, commandArg)
+	case "docs":
+		handleDocsCommand(bot, update, userID, commandArg)
+	case "voice":
+		user := variables.UserSettingsMap.Update(userID, func(user *variables.User) {
+			switch strings.ToLower(strings.TrimSpace(commandArg)) {
+			case "on":
+				user.VoiceMode = true
+			case "off":
+				user.VoiceMode = false
+			default:
+				user.VoiceMode = !user.VoiceMode
+			}
+		})
+		text := "已关闭语音回复."
+		if user.VoiceMode {
+			text = "已开启语音回复, 文字回复完成后会同时发送语音."
+		}
+		bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, text))
 	default:
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("无效命令: %s", command))
 		bot.Send(msg)
 	}
 }
 
-func escapeMarkdownCode(text string) string {
-	// 在 Markdown V2 中，以下字符需要在前面加上反斜杠进行转义
-	specialChars := []string{"_", "*", "[", "]", "(", ")", "~", "`", ">", "#", "+", "-", "=", "|", "{", "}", ".", "!"}
-	for _, c := range specialChars {
-		text = strings.ReplaceAll(text, c, "\\"+c)
+// visionHint 当前模型不支持图片时的提示
+func visionHint(current conf.ModelConfig) string {
+	var aliases []string
+	for _, model := range models.Vision() {
+		aliases = append(aliases, model.Alias)
 	}
-	return text
-}
-
-func isCode(text string) bool {
-	// 一些常见的编程关键字
-	keywords := []string{
-		"class", "public", "private", "protected", "import", "package", // Java
-		"func", "interface", "struct", "chan", "go", "select", // Go
-		"def", "import", "class", "from", "self", "lambda", // Python
-		"int", "char", "float", "double", "printf", "scanf", // C
-		"cout", "cin", "namespace", "std", "nullptr", // C++
-		"if", "else", "while", "for", "switch", "case", // Common to many languages
-		"echo", "function", "if", "[", "]", // Shell
-	}
-
-	// 一些编程语言特有的字符或模式
-	patterns := []string{
-		"{", "}", "(", ")", ";", "[]", "#include", "//", "/*", "*/", "=>", // Common syntax elements
-		"public static void main", // Java
-		"fmt.", "go func",         // Go
-		"def __init__",                  // Python
-		"using namespace",               // C++
-		"#!/bin/bash", "#!/usr/bin/env", // Shell shebang
+	if len(aliases) == 0 {
+		return fmt.Sprintf("当前模型 %s 不支持图片, 模型目录中没有支持图片的模型.", current.Alias)
 	}
+	return fmt.Sprintf("当前模型 %s 不支持图片, 请使用 /model 切换到: %s", current.Alias, strings.Join(aliases, ", "))
+}
 
-	for _, keyword := range keywords {
-		if strings.Contains(text, keyword) {
-			return true
+// sendRendered 将 Markdown 渲染为 Telegram HTML 后发送，messageID 不为 0 时编辑该消息。
+// Telegram 拒绝渲染结果（无法解析、链接无效等 400 错误）时退回纯文本。
+func sendRendered(bot *tgbotapi.BotAPI, chatID int64, messageID int, text string) (tgbotapi.Message, error) {
+	send := func(content, parseMode string) (tgbotapi.Message, error) {
+		if messageID == 0 {
+			msg := tgbotapi.NewMessage(chatID, content)
+			msg.ParseMode = parseMode
+			return send(bot, chatID, msg)
 		}
+		msg := tgbotapi.NewEditMessageText(chatID, messageID, content)
+		msg.ParseMode = parseMode
+		return send(bot, chatID, msg)
 	}
 
-	for _, pattern := range patterns {
-		if strings.Contains(text, pattern) {
-			return true
-		}
+	msg, err := send(markdown.ToTelegramHTML(text), tgbotapi.ModeHTML)
+	if isBadRequest(err) && !isNotModified(err) {
+		log.Printf("Failed to send rendered reply, falling back to plain text: %v", err)
+		return send(text, "")
 	}
-
-	// todo 这里可以添加更复杂的检测逻辑，例如正则表达式匹配等
-
-	return false
+	return msg, err
 }
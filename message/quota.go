@@ -0,0 +1,28 @@
+package message
+
+import "time"
+
+const (
+	QuotaResetDaily   = "daily"
+	QuotaResetWeekly  = "weekly"
+	QuotaResetMonthly = "monthly"
+)
+
+// quotaWindow 返回 now 所在配额窗口的起始时间和下一次重置时间。
+// reset 为空或无法识别时配额不重置，两个返回值均为零值。
+func quotaWindow(reset string, now time.Time) (time.Time, time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch reset {
+	case QuotaResetDaily:
+		return today, today.AddDate(0, 0, 1)
+	case QuotaResetWeekly:
+		// 以周一作为一周的开始
+		start := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	case QuotaResetMonthly:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 1, 0)
+	default:
+		return time.Time{}, time.Time{}
+	}
+}
@@ -0,0 +1,178 @@
+package message
+
+import (
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Telegram 的发送频率限制：全局约每秒 30 条，同一私聊每秒 1 条，同一群组每分钟 20 条
+const (
+	globalSendInterval  = time.Second / 30
+	privateSendInterval = time.Second
+	groupSendInterval   = 3 * time.Second
+	maxSendAttempts     = 5
+)
+
+// sendLimiter 为每次发送预约时间窗口，超出频率时阻塞等待
+type sendLimiter struct {
+	mu     sync.Mutex
+	global time.Time
+	chats  map[int64]time.Time
+}
+
+var limiter = &sendLimiter{chats: make(map[int64]time.Time)}
+
+// chatSendInterval 群组和频道的 chatID 为负数
+func chatSendInterval(chatID int64) time.Duration {
+	if chatID < 0 {
+		return groupSendInterval
+	}
+	return privateSendInterval
+}
+
+// wait 阻塞到 chatID 可以发送下一条消息
+func (l *sendLimiter) wait(chatID int64) {
+	l.mu.Lock()
+	at := time.Now()
+	if l.global.After(at) {
+		at = l.global
+	}
+	if next := l.chats[chatID]; next.After(at) {
+		at = next
+	}
+	l.global = at.Add(globalSendInterval)
+	l.chats[chatID] = at.Add(chatSendInterval(chatID))
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(at))
+}
+
+// backoff 收到 429 后，在 retry_after 秒内暂停向该 chat 发送
+func (l *sendLimiter) backoff(chatID int64, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until := time.Now().Add(retryAfter)
+	if l.chats[chatID].Before(until) {
+		l.chats[chatID] = until
+	}
+}
+
+// send 经过限流发送消息，遇到 429 时按 retry_after 等待后重试
+func send(bot *tgbotapi.BotAPI, chatID int64, c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	var err error
+	for attempt := 0; attempt < maxSendAttempts; attempt++ {
+		limiter.wait(chatID)
+		var msg tgbotapi.Message
+		msg, err = bot.Send(c)
+		var tgErr *tgbotapi.Error
+		if err == nil || !errors.As(err, &tgErr) || tgErr.Code != 429 {
+			return msg, err
+		}
+		retryAfter := time.Duration(tgErr.RetryAfter) * time.Second
+		if retryAfter <= 0 {
+			retryAfter = time.Second
+		}
+		log.Printf("Flood control in chat %d, retrying after %v", chatID, retryAfter)
+		limiter.backoff(chatID, retryAfter)
+	}
+	return tgbotapi.Message{}, err
+}
+
+// isBadRequest Telegram 拒绝了请求的内容（400），原样重试不会成功
+func isBadRequest(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && tgErr.Code == 400
+}
+
+// isTransient 网络错误或 Telegram 的 5xx 错误，稍后重试可能成功
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var tgErr *tgbotapi.Error
+	if !errors.As(err, &tgErr) {
+		return true
+	}
+	return tgErr.Code >= 500
+}
+
+// isNotModified 编辑后的内容与消息当前的内容相同
+func isNotModified(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "message is not modified")
+}
+
+// finalFlushAttempts 最终文本遇到网络或 5xx 错误时的最多尝试次数
+const finalFlushAttempts = 3
+
+// editScheduler 合并流式输出的中间状态，按固定间隔把最新的文本交给 flush。
+// 中间状态可能被跳过，但 Finish 传入的最终文本一定会交给 flush。
+// flush 出错时不记为已同步，下一次刷新重新投递。
+type editScheduler struct {
+	mu      sync.Mutex
+	latest  string
+	flushed string
+	flush   func(text string) error
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newEditScheduler(interval time.Duration, flush func(text string) error) *editScheduler {
+	s := &editScheduler{
+		flush: flush,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.run(interval)
+	return s
+}
+
+func (s *editScheduler) run(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			text := s.latest
+			s.mu.Unlock()
+			if text != s.flushed && s.flush(text) == nil {
+				s.flushed = text
+			}
+		}
+	}
+}
+
+// Update 记录最新的文本，不会阻塞
+func (s *editScheduler) Update(text string) {
+	s.mu.Lock()
+	s.latest = text
+	s.mu.Unlock()
+}
+
+// Finish 停止定时刷新，并同步投递最终文本，网络或 5xx 错误时稍后重试
+func (s *editScheduler) Finish(text string) {
+	close(s.stop)
+	<-s.done
+	if text == s.flushed {
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		err := s.flush(text)
+		if err == nil {
+			s.flushed = text
+			return
+		}
+		if attempt >= finalFlushAttempts || !isTransient(err) {
+			log.Printf("Failed to deliver final reply: %v", err)
+			return
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
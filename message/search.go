@@ -0,0 +1,75 @@
+package message
+
+import (
+	"context"
+	"duolaGPT/utils"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// searchLimit 联网搜索结果交给模型的最大字符数
+const searchLimit = 10000
+
+// defaultPageQuestion /url 没有附带问题时的提问
+const defaultPageQuestion = "总结这个页面的主要内容."
+
+// searchUsage 返回 /search 和 /url 缺少参数时的用法说明，参数齐全时返回空字符串
+func searchUsage(command, arg string) string {
+	if strings.TrimSpace(arg) != "" {
+		return ""
+	}
+	switch command {
+	case "search":
+		return "用法: /search 问题, 如 /search 今天的新闻"
+	case "url":
+		return "用法: /url 链接 [问题], 如 /url https://go.dev/blog 最近有哪些更新"
+	}
+	return ""
+}
+
+// pagePrompt 抓取 /url 指定的页面并构造提问，失败时回复用户并返回 false
+func pagePrompt(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update, arg string) (string, []utils.SearchSource, bool) {
+	fields := strings.Fields(arg)
+	link, ok := pageURL(fields[0])
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "链接无效, 只支持 http 和 https 链接."))
+		return "", nil, false
+	}
+	question := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(arg), fields[0]))
+	if question == "" {
+		question = defaultPageQuestion
+	}
+
+	page, err := utils.FetchPage(ctx, link)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to fetch %s: %v", link, err)
+			text := "页面抓取失败, 请检查链接或稍后再试."
+			switch {
+			case errors.Is(err, utils.ErrNoContent):
+				text = "没有从页面中提取到正文."
+			case errors.Is(err, utils.ErrForbiddenAddress):
+				text = "不能抓取本机或内网地址."
+			}
+			bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+		}
+		return "", nil, false
+	}
+	sources := []utils.SearchSource{page}
+	return utils.PagePrompt(question, page), sources, true
+}
+
+// pageURL 校验链接，省略协议时按 https 处理
+func pageURL(link string) (string, bool) {
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return u.String(), true
+}
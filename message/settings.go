@@ -0,0 +1,188 @@
+package message
+
+import (
+	"duolaGPT/gptMessage"
+	"duolaGPT/models"
+	"duolaGPT/variables"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// settingsCallbackPrefix 设置面板按钮的回调数据为 set:<面板所有者>:<设置项>:<选项序号>，
+// 使用序号而不是模型别名等原始值，避免超出 Telegram 回调数据 64 字节的限制
+const settingsCallbackPrefix = "set:"
+
+// settingsTemperatures /settings 中可选的温度
+var settingsTemperatures = []float32{0, 0.2, 0.7, 1.0}
+
+// settingsLanguages /settings 中可选的回复语言，key 会写入 system prompt
+var settingsLanguages = []struct {
+	Key   string
+	Label string
+}{
+	{"Chinese", "中文"},
+	{"English", "English"},
+	{"Japanese", "日本語"},
+}
+
+// settingsText 返回设置面板的文字说明
+func settingsText(user variables.User) string {
+	search := "开"
+	if user.SearchDisabled {
+		search = "关"
+	}
+	return fmt.Sprintf("当前设置:\n模型: %s\n温度: %.1f\n自动联网搜索: %s\n回复语言: %s\n点击下方按钮修改.",
+		models.Resolve(user.Model).Alias, gptMessage.Temperature(user), search, userLanguage(user))
+}
+
+// settingsData 生成按钮的回调数据
+func settingsData(owner int64, key string, index int) string {
+	return fmt.Sprintf("%s%d:%s:%d", settingsCallbackPrefix, owner, key, index)
+}
+
+// settingsKeyboard 按当前设置生成内联键盘，选中项前加 ✅，owner 为打开面板的用户
+func settingsKeyboard(owner int64, user variables.User) tgbotapi.InlineKeyboardMarkup {
+	checked := func(selected bool, label string) string {
+		if selected {
+			return "✅ " + label
+		}
+		return label
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	current := models.Resolve(user.Model)
+	var row []tgbotapi.InlineKeyboardButton
+	for i, model := range models.All() {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(checked(model.Alias == current.Alias, model.Alias), settingsData(owner, "model", i)))
+		if len(row) == 3 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	row = nil
+	temperature := gptMessage.Temperature(user)
+	for i, t := range settingsTemperatures {
+		label := "🌡 " + strconv.FormatFloat(float64(t), 'f', 1, 32)
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(checked(t == temperature, label), settingsData(owner, "temp", i)))
+	}
+	rows = append(rows, row)
+
+	search := "🔍 自动联网搜索: 开"
+	if user.SearchDisabled {
+		search = "🔍 自动联网搜索: 关"
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(search, settingsData(owner, "search", 0))))
+
+	row = nil
+	language := userLanguage(user)
+	for i, l := range settingsLanguages {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(checked(l.Key == language, l.Label), settingsData(owner, "lang", i)))
+	}
+	rows = append(rows, row)
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// userLanguage 返回会话的回复语言，未设置时为中文
+func userLanguage(user variables.User) string {
+	if user.Language == "" {
+		return variables.DefaultLanguage
+	}
+	return user.Language
+}
+
+// applySetting 按回调数据修改会话设置，index 为选项在面板中的序号，返回给用户的提示
+func applySetting(user *variables.User, key string, index int) (string, bool) {
+	switch key {
+	case "model":
+		all := models.All()
+		if index < 0 || index >= len(all) {
+			return "未知模型", false
+		}
+		user.Model = all[index].Alias
+		return "已切换到 " + all[index].Alias, true
+	case "temp":
+		if index < 0 || index >= len(settingsTemperatures) {
+			return "无效温度", false
+		}
+		temperature := settingsTemperatures[index]
+		user.Temperature = &temperature
+		return "温度已设置为 " + strconv.FormatFloat(float64(temperature), 'f', 1, 32), true
+	case "search":
+		user.SearchDisabled = !user.SearchDisabled
+		if user.SearchDisabled {
+			return "已关闭自动联网搜索", true
+		}
+		return "已开启自动联网搜索", true
+	case "lang":
+		if index < 0 || index >= len(settingsLanguages) {
+			return "未知语言", false
+		}
+		user.Language = settingsLanguages[index].Key
+		return "回复语言已设置为 " + settingsLanguages[index].Label, true
+	default:
+		return "未知设置", false
+	}
+}
+
+// HandleSettings 回复 /settings 命令，发送带内联键盘的设置面板
+func HandleSettings(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	user := variables.UserSettingsMap.Get(update.Message.From.ID + update.Message.Chat.ID)
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, settingsText(user))
+	msg.ReplyMarkup = settingsKeyboard(update.Message.From.ID, user)
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Failed to send settings: %v", err)
+	}
+}
+
+// HandleCallback 处理设置面板的按钮回调，修改会话设置并刷新面板
+func HandleCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	query := update.CallbackQuery
+	if query.Message == nil || !strings.HasPrefix(query.Data, settingsCallbackPrefix) {
+		bot.Request(tgbotapi.NewCallback(query.ID, ""))
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(query.Data, settingsCallbackPrefix), ":")
+	if len(parts) != 3 {
+		bot.Request(tgbotapi.NewCallback(query.ID, "无效操作"))
+		return
+	}
+	owner, err := strconv.ParseInt(parts[0], 10, 64)
+	index, indexErr := strconv.Atoi(parts[2])
+	if err != nil || indexErr != nil {
+		bot.Request(tgbotapi.NewCallback(query.ID, "无效操作"))
+		return
+	}
+	// 面板显示的是打开它的用户的设置，群里其他成员点击时不修改
+	if owner != query.From.ID {
+		bot.Request(tgbotapi.NewCallbackWithAlert(query.ID, "这是其他成员的设置面板, 请发送 /settings 打开自己的."))
+		return
+	}
+
+	userID := query.From.ID + query.Message.Chat.ID
+	var notice, before string
+	var ok bool
+	user := variables.UserSettingsMap.Update(userID, func(user *variables.User) {
+		before = settingsText(*user)
+		notice, ok = applySetting(user, parts[1], index)
+	})
+	if _, err := bot.Request(tgbotapi.NewCallback(query.ID, notice)); err != nil {
+		log.Printf("Failed to answer callback: %v", err)
+	}
+	// 内容没有变化时 Telegram 会拒绝编辑
+	if !ok || settingsText(user) == before {
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, settingsText(user), settingsKeyboard(owner, user))
+	if _, err := bot.Send(edit); err != nil {
+		log.Printf("Failed to update settings message: %v", err)
+	}
+}
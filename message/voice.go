@@ -0,0 +1,147 @@
+package message
+
+import (
+	"duolaGPT/conf"
+	"duolaGPT/gptMessage"
+	"duolaGPT/markdown"
+	"duolaGPT/provider"
+	"duolaGPT/variables"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"html"
+	"log"
+)
+
+// audioExtensions 音频文件没有文件名时，按 MIME 类型补全扩展名供转写接口识别格式
+var audioExtensions = map[string]string{
+	"audio/ogg":   ".ogg",
+	"audio/opus":  ".ogg",
+	"audio/mpeg":  ".mp3",
+	"audio/mp4":   ".m4a",
+	"audio/x-m4a": ".m4a",
+	"audio/wav":   ".wav",
+	"audio/x-wav": ".wav",
+	"audio/webm":  ".webm",
+	"audio/flac":  ".flac",
+}
+
+// audioFile 返回消息中语音或音频的文件 ID 和文件名，没有音频时 ok 为 false
+func audioFile(msg *tgbotapi.Message) (fileID, fileName string, fileSize int, ok bool) {
+	switch {
+	case msg == nil:
+		return "", "", 0, false
+	case msg.Voice != nil:
+		// 语音消息固定为 OGG/Opus
+		return msg.Voice.FileID, "voice.ogg", msg.Voice.FileSize, true
+	case msg.Audio != nil:
+		fileName = msg.Audio.FileName
+		if fileName == "" {
+			ext, found := audioExtensions[msg.Audio.MimeType]
+			if !found {
+				ext = ".mp3"
+			}
+			fileName = "audio" + ext
+		}
+		return msg.Audio.FileID, fileName, msg.Audio.FileSize, true
+	}
+	return "", "", 0, false
+}
+
+// transcribeMessage 下载消息中的语音或音频并转写为文字
+func transcribeMessage(bot *tgbotapi.BotAPI, providers *provider.Registry, msg *tgbotapi.Message) (string, error) {
+	fileID, fileName, fileSize, ok := audioFile(msg)
+	if !ok {
+		return "", fmt.Errorf("message %d has no audio", msg.MessageID)
+	}
+	if fileSize > maxDownloadSize {
+		return "", fmt.Errorf("audio %s is larger than %d bytes", fileID, maxDownloadSize)
+	}
+	data, err := downloadFile(bot, fileID)
+	if err != nil {
+		return "", err
+	}
+	return gptMessage.Transcribe(providers, data, fileName)
+}
+
+// replyTranscript 以回复原消息的方式发送转写文字，quoted 为 true 时以引用块展示
+func replyTranscript(bot *tgbotapi.BotAPI, original *tgbotapi.Message, text string, quoted bool) {
+	render := func(part string) string { return part }
+	if quoted {
+		render = func(part string) string { return "<blockquote>" + html.EscapeString(part) + "</blockquote>" }
+	}
+	for _, part := range markdown.SplitRendered(text, messageLimit, render) {
+		msg := tgbotapi.NewMessage(original.Chat.ID, part)
+		msg.ReplyToMessageID = original.MessageID
+		if quoted {
+			msg.Text = render(part)
+			msg.ParseMode = tgbotapi.ModeHTML
+		}
+		_, err := send(bot, original.Chat.ID, msg)
+		if quoted && isBadRequest(err) {
+			msg.Text = part
+			msg.ParseMode = ""
+			_, err = send(bot, original.Chat.ID, msg)
+		}
+		if err != nil {
+			log.Printf("Failed to send transcript: %v", err)
+			return
+		}
+	}
+}
+
+// transcribeOrReport 转写消息中的音频，失败或没有识别到内容时提示用户并返回 false
+func transcribeOrReport(bot *tgbotapi.BotAPI, providers *provider.Registry, msg *tgbotapi.Message) (string, bool) {
+	text, err := transcribeMessage(bot, providers, msg)
+	if err != nil {
+		log.Printf("Failed to transcribe audio: %v", err)
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "语音转写失败, 请重试."))
+		return "", false
+	}
+	if text == "" {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "未识别到语音内容."))
+		return "", false
+	}
+	return text, true
+}
+
+// HandleTranscribe 处理 /transcribe 命令：回复一条语音时直接转写，否则转写用户发送的下一条语音，只返回文字不请求对话模型
+func HandleTranscribe(userManager *UserManager, config conf.Config, bot *tgbotapi.BotAPI, update tgbotapi.Update, providers *provider.Registry) {
+	target := update.Message.ReplyToMessage
+	if _, _, _, ok := audioFile(target); !ok {
+		chatID := update.Message.From.ID + update.Message.Chat.ID
+		variables.UserSettingsMap.Update(chatID, func(user *variables.User) {
+			user.State = variables.StateWaitingForTranscription
+		})
+		bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "请发送要转写的语音或音频."))
+		return
+	}
+	if !userManager.CheckUserAccess(config, update, bot) {
+		return // 如果用户没有访问权限，则直接返回。
+	}
+	if text, ok := transcribeOrReport(bot, providers, target); ok {
+		replyTranscript(bot, target, text, false)
+	}
+}
+
+// replyVoice 将回复合成为语音并以语音消息发送，回复较长时分多条发送
+func replyVoice(bot *tgbotapi.BotAPI, providers *provider.Registry, original *tgbotapi.Message, text string) {
+	voices, err := gptMessage.Synthesize(providers, text)
+	if err != nil {
+		log.Printf("Failed to synthesize speech: %v", err)
+		if len(voices) == 0 {
+			bot.Send(tgbotapi.NewMessage(original.Chat.ID, "语音合成失败."))
+			return
+		}
+	}
+	for i, voice := range voices {
+		msg := tgbotapi.NewVoice(original.Chat.ID, tgbotapi.FileBytes{
+			Name:  fmt.Sprintf("reply-%d.ogg", i+1),
+			Bytes: voice,
+		})
+		msg.ReplyToMessageID = original.MessageID
+		if _, err := send(bot, original.Chat.ID, msg); err != nil {
+			log.Printf("Failed to send voice: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"duolaGPT/conf"
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultContextSize = 4096
+	// DefaultMaxOutputTokens 未配置 max_output_tokens 时的回复长度上限，不超过上下文的四分之一
+	DefaultMaxOutputTokens = 1024
+	DefaultImageModel      = "dall-e-3"
+)
+
+// defaultCatalog 配置文件中没有 models 时使用的内置模型
+var defaultCatalog = []conf.ModelConfig{
+	{Alias: "gpt3", Name: "gpt-3.5-turbo-16k", ContextSize: 16385, MaxOutputTokens: 4096, InputPrice: 0.003, OutputPrice: 0.004, Tools: true},
+	{Alias: "gpt4", Name: "gpt-4-1106-preview", ContextSize: 128000, MaxOutputTokens: 4096, InputPrice: 0.01, OutputPrice: 0.03, Tools: true},
+	{Alias: "gpt4v", Name: "gpt-4-vision-preview", ContextSize: 128000, MaxOutputTokens: 4096, InputPrice: 0.01, OutputPrice: 0.03, Vision: true},
+}
+
+var (
+	catalog      = defaultCatalog
+	defaultAlias = defaultCatalog[0].Alias
+	imageModel   = DefaultImageModel
+)
+
+// Init 从配置加载模型目录，需要在 provider 创建之前调用
+func Init(config conf.Config) error {
+	if len(config.Models) > 0 {
+		catalog = nil
+		seen := make(map[string]bool)
+		for _, model := range config.Models {
+			if model.Name == "" {
+				return fmt.Errorf("model %q: name is required", model.Alias)
+			}
+			if model.Alias == "" {
+				model.Alias = model.Name
+			}
+			if seen[model.Alias] {
+				return fmt.Errorf("duplicate model alias %s", model.Alias)
+			}
+			seen[model.Alias] = true
+			if model.ContextSize == 0 {
+				model.ContextSize = DefaultContextSize
+			}
+			if model.MaxOutputTokens == 0 {
+				model.MaxOutputTokens = DefaultMaxOutputTokens
+				if model.MaxOutputTokens > model.ContextSize/4 {
+					model.MaxOutputTokens = model.ContextSize / 4
+				}
+			}
+			// 回复占满上下文时没有空间留给对话历史
+			if model.MaxOutputTokens >= model.ContextSize {
+				return fmt.Errorf("model %s: max_output_tokens %d must be less than context_size %d", model.Alias, model.MaxOutputTokens, model.ContextSize)
+			}
+			catalog = append(catalog, model)
+		}
+		defaultAlias = catalog[0].Alias
+	}
+	if config.DefaultModel != "" {
+		model, ok := Lookup(config.DefaultModel)
+		if !ok {
+			return fmt.Errorf("default_model %s is not in models", config.DefaultModel)
+		}
+		defaultAlias = model.Alias
+	}
+	if config.ImageModel != "" {
+		imageModel = config.ImageModel
+	}
+	return nil
+}
+
+// Lookup 按别名查找模型，也兼容直接使用上游模型名
+func Lookup(alias string) (conf.ModelConfig, bool) {
+	alias = strings.TrimSpace(alias)
+	for _, model := range catalog {
+		if strings.EqualFold(model.Alias, alias) {
+			return model, true
+		}
+	}
+	for _, model := range catalog {
+		if model.Name == alias {
+			return model, true
+		}
+	}
+	return conf.ModelConfig{}, false
+}
+
+// Resolve 查找会话使用的模型，找不到时返回默认模型
+func Resolve(alias string) conf.ModelConfig {
+	if model, ok := Lookup(alias); ok {
+		return model
+	}
+	return Default()
+}
+
+func Default() conf.ModelConfig {
+	model, _ := Lookup(defaultAlias)
+	return model
+}
+
+// All 返回目录中的所有模型
+func All() []conf.ModelConfig {
+	return append([]conf.ModelConfig(nil), catalog...)
+}
+
+// Vision 返回目录中所有可以接收图片的模型
+func Vision() []conf.ModelConfig {
+	var vision []conf.ModelConfig
+	for _, model := range catalog {
+		if model.Vision {
+			vision = append(vision, model)
+		}
+	}
+	return vision
+}
+
+// Image 返回绘图使用的模型
+func Image() conf.ModelConfig {
+	if model, ok := Lookup(imageModel); ok {
+		return model
+	}
+	return conf.ModelConfig{Alias: imageModel, Name: imageModel}
+}
+
+// Cost 按每千 token 单价计算费用(美元)
+func Cost(model conf.ModelConfig, promptTokens, completionTokens int) float64 {
+	return (float64(promptTokens)*model.InputPrice + float64(completionTokens)*model.OutputPrice) / 1000
+}
@@ -0,0 +1,21 @@
+package provider
+
+import (
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+)
+
+// embeddingVectors 按 Index 排列接口返回的向量，数量与输入不一致时报错
+func embeddingVectors(data []openai.Embedding, count int) ([][]float32, error) {
+	if len(data) != count {
+		return nil, fmt.Errorf("got %d embeddings for %d inputs", len(data), count)
+	}
+	vectors := make([][]float32, count)
+	for _, embedding := range data {
+		if embedding.Index < 0 || embedding.Index >= count {
+			return nil, fmt.Errorf("embedding index %d out of range", embedding.Index)
+		}
+		vectors[embedding.Index] = embedding.Embedding
+	}
+	return vectors, nil
+}
@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Local 访问 Ollama、llama.cpp server 等本地部署的模型，二者都提供 OpenAI 兼容的 /v1/chat/completions 接口。
+// 本地模型不支持绘图和语音。
+type Local struct {
+	client     *openai.Client
+	apiKey     string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewLocal 创建本地模型 provider，baseURL 形如 http://127.0.0.1:11434/v1，apiKey 可为空
+func NewLocal(apiKey, baseURL string, httpClient *http.Client) *Local {
+	config := openai.DefaultConfig(apiKey)
+	config.BaseURL = baseURL
+	config.HTTPClient = httpClient
+	return &Local{
+		client:     openai.NewClientWithConfig(config),
+		apiKey:     apiKey,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+func (p *Local) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (ChatStream, error) {
+	return p.client.CreateChatCompletionStream(ctx, request)
+}
+
+func (p *Local) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return p.client.CreateChatCompletion(ctx, request)
+}
+
+func (p *Local) CreateImage(ctx context.Context, request openai.ImageRequest) (openai.ImageResponse, error) {
+	return openai.ImageResponse{}, ErrNotSupported
+}
+
+func (p *Local) CreateTranscription(ctx context.Context, request openai.AudioRequest) (openai.AudioResponse, error) {
+	return openai.AudioResponse{}, ErrNotSupported
+}
+
+func (p *Local) CreateSpeech(ctx context.Context, request openai.CreateSpeechRequest) (io.ReadCloser, error) {
+	return nil, ErrNotSupported
+}
+
+// CreateEmbeddings 直接请求 OpenAI 兼容的 /embeddings 接口，本地模型名不受 go-openai 的枚举限制
+func (p *Local) CreateEmbeddings(ctx context.Context, input []string, model string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]any{"model": model, "input": input})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("embeddings request failed with status %s: %s", resp.Status, message)
+	}
+	var response openai.EmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return embeddingVectors(response.Data, len(input))
+}
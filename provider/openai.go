@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"net/http"
+)
+
+// OpenAI 通过 go-openai 访问 OpenAI 或兼容接口
+type OpenAI struct {
+	client *openai.Client
+}
+
+func NewOpenAI(apiKey, baseURL string, httpClient *http.Client) *OpenAI {
+	config := openai.DefaultConfig(apiKey)
+	if baseURL != "" {
+		config.BaseURL = baseURL
+	}
+	config.HTTPClient = httpClient
+	return &OpenAI{client: openai.NewClientWithConfig(config)}
+}
+
+// NewAzure 创建 Azure OpenAI provider，deployments 将模型名映射为部署名，未配置的模型沿用 go-openai 的默认映射
+func NewAzure(apiKey, baseURL, apiVersion string, deployments map[string]string, httpClient *http.Client) *OpenAI {
+	config := openai.DefaultAzureConfig(apiKey, baseURL)
+	if apiVersion != "" {
+		config.APIVersion = apiVersion
+	}
+	defaultMapper := config.AzureModelMapperFunc
+	config.AzureModelMapperFunc = func(model string) string {
+		if deployment, ok := deployments[model]; ok {
+			return deployment
+		}
+		return defaultMapper(model)
+	}
+	config.HTTPClient = httpClient
+	return &OpenAI{client: openai.NewClientWithConfig(config)}
+}
+
+func (p *OpenAI) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (ChatStream, error) {
+	return p.client.CreateChatCompletionStream(ctx, request)
+}
+
+func (p *OpenAI) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return p.client.CreateChatCompletion(ctx, request)
+}
+
+func (p *OpenAI) CreateImage(ctx context.Context, request openai.ImageRequest) (openai.ImageResponse, error) {
+	return p.client.CreateImage(ctx, request)
+}
+
+func (p *OpenAI) CreateTranscription(ctx context.Context, request openai.AudioRequest) (openai.AudioResponse, error) {
+	return p.client.CreateTranscription(ctx, request)
+}
+
+func (p *OpenAI) CreateSpeech(ctx context.Context, request openai.CreateSpeechRequest) (io.ReadCloser, error) {
+	return p.client.CreateSpeech(ctx, request)
+}
+
+// CreateEmbeddings go-openai 只接受它已知的 embedding 模型名
+func (p *OpenAI) CreateEmbeddings(ctx context.Context, input []string, model string) ([][]float32, error) {
+	var embeddingModel openai.EmbeddingModel
+	if err := embeddingModel.UnmarshalText([]byte(model)); err != nil || embeddingModel == openai.Unknown {
+		return nil, fmt.Errorf("unsupported embedding model %s", model)
+	}
+	response, err := p.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{Input: input, Model: embeddingModel})
+	if err != nil {
+		return nil, err
+	}
+	return embeddingVectors(response.Data, len(input))
+}
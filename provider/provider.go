@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"context"
+	"duolaGPT/conf"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"net/http"
+)
+
+const (
+	TypeOpenAI = "openai"
+	TypeAzure  = "azure"
+	TypeLocal  = "local"
+
+	// DefaultName 由顶层 openai_api_key/base_url 生成的默认 provider
+	DefaultName = "openai"
+)
+
+var ErrNotSupported = errors.New("operation not supported by provider")
+
+// ChatStream 流式对话的响应
+type ChatStream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close()
+}
+
+// Provider 对话与绘图后端。请求和响应沿用 go-openai 的结构，各实现负责转换到自己的接口
+type Provider interface {
+	CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (ChatStream, error)
+	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	CreateImage(ctx context.Context, request openai.ImageRequest) (openai.ImageResponse, error)
+	CreateTranscription(ctx context.Context, request openai.AudioRequest) (openai.AudioResponse, error)
+	CreateSpeech(ctx context.Context, request openai.CreateSpeechRequest) (io.ReadCloser, error)
+	// CreateEmbeddings 返回与 input 一一对应的向量
+	CreateEmbeddings(ctx context.Context, input []string, model string) ([][]float32, error)
+}
+
+// Registry 按名称管理所有 provider
+type Registry struct {
+	providers map[string]Provider
+}
+
+// NewRegistry 根据配置创建所有 provider，httpClient 用于访问外部服务（可带代理）
+func NewRegistry(config conf.Config, httpClient *http.Client) (*Registry, error) {
+	registry := &Registry{
+		providers: map[string]Provider{
+			DefaultName: NewOpenAI(config.OpenAIKey, config.BaseUrl, httpClient),
+		},
+	}
+	for _, providerConfig := range config.Providers {
+		p, err := newProvider(providerConfig, httpClient)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %v", providerConfig.Name, err)
+		}
+		registry.providers[providerConfig.Name] = p
+	}
+	if _, ok := registry.providers[config.EmbeddingProvider]; config.EmbeddingProvider != "" && !ok {
+		return nil, fmt.Errorf("embedding_provider %s is not configured", config.EmbeddingProvider)
+	}
+	for _, model := range config.Models {
+		if _, ok := registry.providers[model.Provider]; model.Provider != "" && !ok {
+			return nil, fmt.Errorf("model %s uses unknown provider %s", model.Alias, model.Provider)
+		}
+	}
+	return registry, nil
+}
+
+func newProvider(config conf.ProviderConfig, httpClient *http.Client) (Provider, error) {
+	if config.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	switch config.Type {
+	case "", TypeOpenAI:
+		return NewOpenAI(config.APIKey, config.BaseURL, httpClient), nil
+	case TypeAzure:
+		if config.BaseURL == "" {
+			return nil, errors.New("base_url is required for azure")
+		}
+		return NewAzure(config.APIKey, config.BaseURL, config.APIVersion, config.Deployments, httpClient), nil
+	case TypeLocal:
+		if config.BaseURL == "" {
+			return nil, errors.New("base_url is required for local")
+		}
+		// 本地/内网模型不走代理
+		return NewLocal(config.APIKey, config.BaseURL, &http.Client{}), nil
+	default:
+		return nil, fmt.Errorf("unknown provider type %s", config.Type)
+	}
+}
+
+// Default 返回由顶层 openai_api_key/base_url 生成的默认 provider
+func (r *Registry) Default() Provider {
+	return r.providers[DefaultName]
+}
+
+// Get 返回名为 name 的 provider，不存在时使用默认 provider
+func (r *Registry) Get(name string) Provider {
+	if p, ok := r.providers[name]; ok {
+		return p
+	}
+	return r.Default()
+}
+
+// ForModel 返回模型目录中为 model 配置的 provider，未配置时使用默认 provider
+func (r *Registry) ForModel(model conf.ModelConfig) Provider {
+	return r.Get(model.Provider)
+}
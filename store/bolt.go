@@ -0,0 +1,274 @@
+package store
+
+import (
+	"encoding/json"
+	"github.com/sashabaranov/go-openai"
+	bolt "go.etcd.io/bbolt"
+	"log"
+	"strconv"
+	"time"
+)
+
+var (
+	conversationBucket = []byte("conversations")
+	settingsBucket     = []byte("settings")
+	quotaBucket        = []byte("quota")
+	documentBucket     = []byte("documents")
+	cacheBucket        = []byte("cache")
+)
+
+// OpenBolt 打开（或创建）BoltDB 文件
+func OpenBolt(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+}
+
+// BoltConversationStore 将对话历史以 JSON 形式保存在 BoltDB 文件中
+type BoltConversationStore struct {
+	db *bolt.DB
+}
+
+func NewBoltConversationStore(db *bolt.DB) (*BoltConversationStore, error) {
+	if err := createBucket(db, conversationBucket); err != nil {
+		return nil, err
+	}
+	return &BoltConversationStore{db: db}, nil
+}
+
+func createBucket(db *bolt.DB, name []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(name)
+		return err
+	})
+}
+
+func chatKey(chatID int64) []byte {
+	return []byte(strconv.FormatInt(chatID, 10))
+}
+
+func (s *BoltConversationStore) Get(chatID int64) []openai.ChatCompletionMessage {
+	var messages []openai.ChatCompletionMessage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(conversationBucket).Get(chatKey(chatID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &messages)
+	})
+	if err != nil {
+		log.Printf("Failed to load conversation %d: %v", chatID, err)
+	}
+	return messages
+}
+
+func (s *BoltConversationStore) Set(chatID int64, messages []openai.ChatCompletionMessage) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putMessages(tx, chatID, messages)
+	})
+	if err != nil {
+		log.Printf("Failed to save conversation %d: %v", chatID, err)
+	}
+}
+
+func (s *BoltConversationStore) Append(chatID int64, messages ...openai.ChatCompletionMessage) {
+	s.Update(chatID, func(history []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+		return append(history, messages...)
+	})
+}
+
+func (s *BoltConversationStore) Update(chatID int64, fn func(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var history []openai.ChatCompletionMessage
+		if data := tx.Bucket(conversationBucket).Get(chatKey(chatID)); data != nil {
+			if err := json.Unmarshal(data, &history); err != nil {
+				return err
+			}
+		}
+		return putMessages(tx, chatID, fn(history))
+	})
+	if err != nil {
+		log.Printf("Failed to update conversation %d: %v", chatID, err)
+	}
+}
+
+func putMessages(tx *bolt.Tx, chatID int64, messages []openai.ChatCompletionMessage) error {
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(conversationBucket).Put(chatKey(chatID), data)
+}
+
+// BoltSettingsStore 将会话设置保存在 BoltDB 文件中
+type BoltSettingsStore struct {
+	db *bolt.DB
+}
+
+func NewBoltSettingsStore(db *bolt.DB) (*BoltSettingsStore, error) {
+	if err := createBucket(db, settingsBucket); err != nil {
+		return nil, err
+	}
+	return &BoltSettingsStore{db: db}, nil
+}
+
+func (s *BoltSettingsStore) Load(chatID int64) ([]byte, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(settingsBucket).Get(chatKey(chatID)); v != nil {
+			// bolt 返回的切片只在事务内有效
+			data = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return data, err
+}
+
+func (s *BoltSettingsStore) Save(chatID int64, data []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(settingsBucket).Put(chatKey(chatID), data)
+	})
+}
+
+// BoltQuotaStore 将配额计数保存在 BoltDB 文件中，重启后不会清零
+type BoltQuotaStore struct {
+	db *bolt.DB
+}
+
+func NewBoltQuotaStore(db *bolt.DB) (*BoltQuotaStore, error) {
+	if err := createBucket(db, quotaBucket); err != nil {
+		return nil, err
+	}
+	return &BoltQuotaStore{db: db}, nil
+}
+
+func (s *BoltQuotaStore) Increment(userID int64, windowStart time.Time) (int, error) {
+	var count int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(quotaBucket)
+		record := quotaRecord{WindowStart: windowStart}
+		if data := bucket.Get(chatKey(userID)); data != nil {
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+		}
+		count = record.increment(windowStart)
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(chatKey(userID), data)
+	})
+	return count, err
+}
+
+// BoltDocumentStore 将会话上传的文档保存在 BoltDB 文件中
+type BoltDocumentStore struct {
+	db *bolt.DB
+}
+
+func NewBoltDocumentStore(db *bolt.DB) (*BoltDocumentStore, error) {
+	if err := createBucket(db, documentBucket); err != nil {
+		return nil, err
+	}
+	return &BoltDocumentStore{db: db}, nil
+}
+
+func (s *BoltDocumentStore) Get(chatID int64) ([]Document, error) {
+	var documents []Document
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(documentBucket).Get(chatKey(chatID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &documents)
+	})
+	return documents, err
+}
+
+func (s *BoltDocumentStore) Update(chatID int64, fn func(documents []Document) []Document) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(documentBucket)
+		var documents []Document
+		if data := bucket.Get(chatKey(chatID)); data != nil {
+			if err := json.Unmarshal(data, &documents); err != nil {
+				return err
+			}
+		}
+		documents = fn(documents)
+		if len(documents) == 0 {
+			return bucket.Delete(chatKey(chatID))
+		}
+		data, err := json.Marshal(documents)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(chatKey(chatID), data)
+	})
+}
+
+// BoltCacheStore 将缓存保存在 BoltDB 文件中，打开时清理已过期的记录
+type BoltCacheStore struct {
+	db *bolt.DB
+}
+
+func NewBoltCacheStore(db *bolt.DB) (*BoltCacheStore, error) {
+	if err := createBucket(db, cacheBucket); err != nil {
+		return nil, err
+	}
+	s := &BoltCacheStore{db: db}
+	if err := s.prune(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *BoltCacheStore) prune() error {
+	now := time.Now()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(cacheBucket)
+		// 遍历时删除会跳过记录，先收集再删除
+		var expired [][]byte
+		err := bucket.ForEach(func(key, data []byte) error {
+			var entry CacheEntry
+			if err := json.Unmarshal(data, &entry); err != nil || now.After(entry.ExpiresAt) {
+				expired = append(expired, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltCacheStore) Get(key string) (CacheEntry, bool, error) {
+	var entry CacheEntry
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(cacheBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+		found = time.Now().Before(entry.ExpiresAt)
+		return nil
+	})
+	return entry, found, err
+}
+
+func (s *BoltCacheStore) Set(key string, entry CacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(cacheBucket).Put([]byte(key), data)
+	})
+}
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"container/list"
+	"github.com/sashabaranov/go-openai"
+	"sync"
+	"time"
+)
+
+// MemoryConversationStore 进程内的对话历史，重启后丢失
+type MemoryConversationStore struct {
+	mu      sync.RWMutex
+	history map[int64][]openai.ChatCompletionMessage
+}
+
+func NewMemoryConversationStore() *MemoryConversationStore {
+	return &MemoryConversationStore{
+		history: make(map[int64][]openai.ChatCompletionMessage),
+	}
+}
+
+func (s *MemoryConversationStore) Get(chatID int64) []openai.ChatCompletionMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]openai.ChatCompletionMessage(nil), s.history[chatID]...)
+}
+
+func (s *MemoryConversationStore) Set(chatID int64, messages []openai.ChatCompletionMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history[chatID] = append([]openai.ChatCompletionMessage(nil), messages...)
+}
+
+func (s *MemoryConversationStore) Append(chatID int64, messages ...openai.ChatCompletionMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history[chatID] = append(s.history[chatID], messages...)
+}
+
+func (s *MemoryConversationStore) Update(chatID int64, fn func(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history[chatID] = fn(append([]openai.ChatCompletionMessage(nil), s.history[chatID]...))
+}
+
+// MemorySettingsStore 进程内的设置存储，重启后丢失
+type MemorySettingsStore struct {
+	mu       sync.RWMutex
+	settings map[int64][]byte
+}
+
+func NewMemorySettingsStore() *MemorySettingsStore {
+	return &MemorySettingsStore{
+		settings: make(map[int64][]byte),
+	}
+}
+
+func (s *MemorySettingsStore) Load(chatID int64) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings[chatID], nil
+}
+
+func (s *MemorySettingsStore) Save(chatID int64, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[chatID] = data
+	return nil
+}
+
+// quotaRecord 配额窗口的起始时间和窗口内的计数
+type quotaRecord struct {
+	WindowStart time.Time `json:"window_start"`
+	Count       int       `json:"count"`
+}
+
+func (r *quotaRecord) increment(windowStart time.Time) int {
+	if !r.WindowStart.Equal(windowStart) {
+		r.WindowStart = windowStart
+		r.Count = 0
+	}
+	r.Count++
+	return r.Count
+}
+
+// MemoryQuotaStore 进程内的配额计数，重启后清零
+type MemoryQuotaStore struct {
+	mu      sync.Mutex
+	records map[int64]*quotaRecord
+}
+
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{
+		records: make(map[int64]*quotaRecord),
+	}
+}
+
+func (s *MemoryQuotaStore) Increment(userID int64, windowStart time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, exists := s.records[userID]
+	if !exists {
+		record = &quotaRecord{WindowStart: windowStart}
+		s.records[userID] = record
+	}
+	return record.increment(windowStart), nil
+}
+
+// MemoryDocumentStore 进程内的文档存储，重启后丢失
+type MemoryDocumentStore struct {
+	mu        sync.RWMutex
+	documents map[int64][]Document
+}
+
+func NewMemoryDocumentStore() *MemoryDocumentStore {
+	return &MemoryDocumentStore{
+		documents: make(map[int64][]Document),
+	}
+}
+
+func (s *MemoryDocumentStore) Get(chatID int64) ([]Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Document(nil), s.documents[chatID]...), nil
+}
+
+func (s *MemoryDocumentStore) Update(chatID int64, fn func(documents []Document) []Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.documents[chatID] = fn(append([]Document(nil), s.documents[chatID]...))
+	return nil
+}
+
+// MemoryCacheStore 进程内的 LRU 缓存，超过 capacity 条时淘汰最久未使用的记录
+type MemoryCacheStore struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type cacheItem struct {
+	key   string
+	entry CacheEntry
+}
+
+func NewMemoryCacheStore(capacity int) *MemoryCacheStore {
+	return &MemoryCacheStore{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryCacheStore) Get(key string) (CacheEntry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.entries[key]
+	if !ok {
+		return CacheEntry{}, false, nil
+	}
+	item := element.Value.(*cacheItem)
+	if time.Now().After(item.entry.ExpiresAt) {
+		s.order.Remove(element)
+		delete(s.entries, key)
+		return CacheEntry{}, false, nil
+	}
+	s.order.MoveToFront(element)
+	return item.entry, true, nil
+}
+
+func (s *MemoryCacheStore) Set(key string, entry CacheEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.entries[key]; ok {
+		element.Value.(*cacheItem).entry = entry
+		s.order.MoveToFront(element)
+		return nil
+	}
+	s.entries[key] = s.order.PushFront(&cacheItem{key: key, entry: entry})
+	for s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*cacheItem).key)
+	}
+	return nil
+}
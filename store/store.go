@@ -0,0 +1,162 @@
+package store
+
+import (
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+const (
+	BackendMemory = "memory"
+	BackendBolt   = "bolt"
+)
+
+// ConversationStore 保存每个会话的对话历史，chatID 为 From.ID+Chat.ID
+type ConversationStore interface {
+	Get(chatID int64) []openai.ChatCompletionMessage
+	Set(chatID int64, messages []openai.ChatCompletionMessage)
+	Append(chatID int64, messages ...openai.ChatCompletionMessage)
+	// Update 原子地读取并替换对话历史
+	Update(chatID int64, fn func(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage)
+}
+
+// SettingsStore 保存每个会话序列化后的设置，Load 在没有记录时返回 nil
+type SettingsStore interface {
+	Load(chatID int64) ([]byte, error)
+	Save(chatID int64, data []byte) error
+}
+
+// QuotaStore 记录用户在当前配额窗口内的对话次数
+type QuotaStore interface {
+	// Increment 为用户计数加一并返回新值，windowStart 与已记录的窗口不同时先清零
+	Increment(userID int64, windowStart time.Time) (int, error)
+}
+
+// Document 会话中上传的文档，Chunks 为切分后的文本块
+type Document struct {
+	Name    string    `json:"name"`
+	Chunks  []string  `json:"chunks"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// DocumentStore 保存每个会话上传的文档
+type DocumentStore interface {
+	Get(chatID int64) ([]Document, error)
+	// Update 原子地读取并替换会话的文档列表
+	Update(chatID int64, fn func(documents []Document) []Document) error
+}
+
+// CacheEntry 缓存的值及其过期时间
+type CacheEntry struct {
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CacheStore 带过期时间的键值缓存，Get 在没有记录或已过期时返回 false
+type CacheStore interface {
+	Get(key string) (CacheEntry, bool, error)
+	Set(key string, entry CacheEntry) error
+}
+
+// Backend 持有具体的存储后端，各类存储共用同一个数据库文件
+type Backend struct {
+	db *bolt.DB
+}
+
+// Open 根据配置的 backend 打开存储，backend 为空时使用内存存储
+func Open(backend, path string) (*Backend, error) {
+	switch backend {
+	case "", BackendMemory:
+		return &Backend{}, nil
+	case BackendBolt:
+		if path == "" {
+			path = "duolaGPT.db"
+		}
+		db, err := OpenBolt(path)
+		if err != nil {
+			return nil, err
+		}
+		return &Backend{db: db}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
+
+func (b *Backend) Conversations() (ConversationStore, error) {
+	if b.db == nil {
+		return NewMemoryConversationStore(), nil
+	}
+	return NewBoltConversationStore(b.db)
+}
+
+func (b *Backend) Settings() (SettingsStore, error) {
+	if b.db == nil {
+		return NewMemorySettingsStore(), nil
+	}
+	return NewBoltSettingsStore(b.db)
+}
+
+func (b *Backend) Quota() (QuotaStore, error) {
+	if b.db == nil {
+		return NewMemoryQuotaStore(), nil
+	}
+	return NewBoltQuotaStore(b.db)
+}
+
+func (b *Backend) Documents() (DocumentStore, error) {
+	if b.db == nil {
+		return NewMemoryDocumentStore(), nil
+	}
+	return NewBoltDocumentStore(b.db)
+}
+
+// Cache 返回最多保存 capacity 条的内存 LRU 缓存。persist 为 true 且使用 bolt 后端时，
+// 内存中淘汰的记录仍可从数据库文件读取，重启后也不会丢失
+func (b *Backend) Cache(capacity int, persist bool) (CacheStore, error) {
+	memory := NewMemoryCacheStore(capacity)
+	if !persist || b.db == nil {
+		return memory, nil
+	}
+	disk, err := NewBoltCacheStore(b.db)
+	if err != nil {
+		return nil, err
+	}
+	return NewTieredCacheStore(memory, disk), nil
+}
+
+// Close 关闭数据库文件，内存后端无需处理
+func (b *Backend) Close() error {
+	if b.db == nil {
+		return nil
+	}
+	return b.db.Close()
+}
+
+// TieredCacheStore 先查内存缓存，未命中时查磁盘缓存并放回内存
+type TieredCacheStore struct {
+	memory CacheStore
+	disk   CacheStore
+}
+
+func NewTieredCacheStore(memory, disk CacheStore) *TieredCacheStore {
+	return &TieredCacheStore{memory: memory, disk: disk}
+}
+
+func (s *TieredCacheStore) Get(key string) (CacheEntry, bool, error) {
+	if entry, ok, err := s.memory.Get(key); ok || err != nil {
+		return entry, ok, err
+	}
+	entry, ok, err := s.disk.Get(key)
+	if !ok || err != nil {
+		return entry, ok, err
+	}
+	return entry, true, s.memory.Set(key, entry)
+}
+
+func (s *TieredCacheStore) Set(key string, entry CacheEntry) error {
+	if err := s.memory.Set(key, entry); err != nil {
+		return err
+	}
+	return s.disk.Set(key, entry)
+}
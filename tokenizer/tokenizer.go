@@ -0,0 +1,83 @@
+package tokenizer
+
+import (
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+	"github.com/sashabaranov/go-openai"
+	"log"
+	"sync"
+)
+
+const (
+	// 不认识的模型（例如本地模型）统一按 cl100k_base 估算
+	fallbackEncoding = "cl100k_base"
+	// imageTokens 单张图片按 high detail 的常见开销估算
+	imageTokens = 765
+)
+
+var (
+	mu        sync.Mutex
+	encodings = make(map[string]*tiktoken.Tiktoken)
+)
+
+func init() {
+	// 使用内置的 BPE 文件，避免运行时去 openai 下载
+	tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+}
+
+func encodingForModel(model string) *tiktoken.Tiktoken {
+	mu.Lock()
+	defer mu.Unlock()
+	if enc, ok := encodings[model]; ok {
+		return enc
+	}
+	enc, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		enc, err = tiktoken.GetEncoding(fallbackEncoding)
+		if err != nil {
+			log.Printf("Failed to load tokenizer for %s: %v", model, err)
+			return nil
+		}
+	}
+	encodings[model] = enc
+	return enc
+}
+
+// CountText 返回文本在 model 下的 token 数，分词器不可用时按 4 字节一个 token 估算
+func CountText(model, text string) int {
+	enc := encodingForModel(model)
+	if enc == nil {
+		return len(text)/4 + 1
+	}
+	return len(enc.Encode(text, nil, nil))
+}
+
+// CountMessage 返回单条消息占用的 token 数，包括每条消息固定的格式开销
+func CountMessage(model string, message openai.ChatCompletionMessage) int {
+	// 参考 openai-cookbook 中 num_tokens_from_messages 的计算方式
+	tokens := 3 + CountText(model, message.Role) + CountText(model, message.Content)
+	for _, part := range message.MultiContent {
+		if part.Type == openai.ChatMessagePartTypeImageURL {
+			tokens += imageTokens
+		} else {
+			tokens += CountText(model, part.Text)
+		}
+	}
+	if message.Name != "" {
+		tokens += 1 + CountText(model, message.Name)
+	}
+	// 工具调用的格式开销没有公开，按名称和参数估算
+	for _, call := range message.ToolCalls {
+		tokens += 3 + CountText(model, call.Function.Name) + CountText(model, call.Function.Arguments)
+	}
+	return tokens
+}
+
+// CountMessages 返回整个请求 messages 的 token 数，包括回复前缀
+func CountMessages(model string, messages []openai.ChatCompletionMessage) int {
+	tokens := 3
+	for _, message := range messages {
+		tokens += CountMessage(model, message)
+	}
+	return tokens
+}
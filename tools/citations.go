@@ -0,0 +1,67 @@
+package tools
+
+import (
+	"context"
+	"duolaGPT/utils"
+	"sync"
+)
+
+// Citations 收集一次回复中引用的全部来源，包括提问前搜索到的和搜索工具返回的，
+// 编号在多次搜索之间连续，同一链接只编号一次
+type Citations struct {
+	mu      sync.Mutex
+	sources []utils.SearchSource
+}
+
+// Add 登记来源，返回每个来源的编号，已登记过的链接沿用原来的编号
+func (c *Citations) Add(sources ...utils.SearchSource) []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	numbers := make([]int, len(sources))
+	for i, source := range sources {
+		numbers[i] = c.number(source.Link)
+		if numbers[i] == 0 {
+			c.sources = append(c.sources, source)
+			numbers[i] = len(c.sources)
+		}
+	}
+	return numbers
+}
+
+// number 返回已登记链接的编号，未登记时返回 0
+func (c *Citations) number(link string) int {
+	for i, source := range c.sources {
+		if source.Link == link {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Sources 返回已收集的全部来源
+func (c *Citations) Sources() []utils.SearchSource {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]utils.SearchSource(nil), c.sources...)
+}
+
+type citationsKey struct{}
+
+// WithCitations 返回携带来源收集器的 context，工具通过它登记引用的来源
+func WithCitations(ctx context.Context, citations *Citations) context.Context {
+	return context.WithValue(ctx, citationsKey{}, citations)
+}
+
+// CitationsFrom 取出 context 中的来源收集器
+func CitationsFrom(ctx context.Context) (*Citations, bool) {
+	citations, ok := ctx.Value(citationsKey{}).(*Citations)
+	return citations, ok
+}
+
+// citationsFrom 取出 context 中的来源收集器，没有时返回一个不会被读取的收集器
+func citationsFrom(ctx context.Context) *Citations {
+	if citations, ok := CitationsFrom(ctx); ok {
+		return citations
+	}
+	return &Citations{}
+}
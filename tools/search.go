@@ -0,0 +1,58 @@
+package tools
+
+import (
+	"context"
+	"duolaGPT/utils"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// WebSearchName 联网搜索工具的名称，会话关闭联网搜索时不提供给模型
+const WebSearchName = "web_search"
+
+// searchLimit 搜索结果交给模型的最大字符数
+const searchLimit = 10000
+
+// WebSearch 联网搜索并返回结果页面的正文
+type WebSearch struct{}
+
+func NewWebSearch() *WebSearch {
+	return &WebSearch{}
+}
+
+func (w *WebSearch) Name() string {
+	return WebSearchName
+}
+
+func (w *WebSearch) Description() string {
+	return "Search the web and return numbered results with title, URL and page text. Use it for recent events, real-time information, facts you are not sure about, or when the user asks you to search. Cite the results you use with their numbers in square brackets, e.g. [1]."
+}
+
+func (w *WebSearch) Parameters() json.RawMessage {
+	return json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"query": {"type": "string", "description": "Search keywords, in the language most likely to find good results"}
+	},
+	"required": ["query"]
+}`)
+}
+
+func (w *WebSearch) Execute(ctx context.Context, arguments string) (string, error) {
+	var args struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(args.Query) == "" {
+		return "", errors.New("query is required")
+	}
+	sources := utils.SearchSources(ctx, args.Query, searchLimit)
+	if len(sources) == 0 {
+		return "No results found.", nil
+	}
+	numbers := citationsFrom(ctx).Add(sources...)
+	return utils.FormatSources(sources, numbers), nil
+}
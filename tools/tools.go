@@ -0,0 +1,148 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Tool 可以被对话模型调用的工具
+type Tool interface {
+	// Name 工具名，只能包含字母、数字、下划线和连字符
+	Name() string
+	// Description 告诉模型工具的用途和调用时机
+	Description() string
+	// Parameters 参数的 JSON Schema
+	Parameters() json.RawMessage
+	// Execute 执行工具，arguments 为模型生成的 JSON 参数，返回交给模型的结果
+	Execute(ctx context.Context, arguments string) (string, error)
+}
+
+// Registry 按名称管理所有工具
+type Registry struct {
+	tools map[string]Tool
+	names []string
+}
+
+func NewRegistry(tools ...Tool) *Registry {
+	registry := &Registry{tools: map[string]Tool{}}
+	for _, tool := range tools {
+		registry.Register(tool)
+	}
+	return registry
+}
+
+// Register 注册工具，同名的工具会被替换
+func (r *Registry) Register(tool Tool) {
+	if _, exists := r.tools[tool.Name()]; !exists {
+		r.names = append(r.names, tool.Name())
+	}
+	r.tools[tool.Name()] = tool
+}
+
+// Len 返回已注册的工具数
+func (r *Registry) Len() int {
+	return len(r.names)
+}
+
+// Has 判断是否注册了名为 name 的工具
+func (r *Registry) Has(name string) bool {
+	_, ok := r.tools[name]
+	return ok
+}
+
+// Definitions 返回请求中使用的工具定义，exclude 中的工具不提供给模型
+func (r *Registry) Definitions(exclude ...string) []openai.Tool {
+	var definitions []openai.Tool
+	for _, name := range r.names {
+		if contains(exclude, name) {
+			continue
+		}
+		tool := r.tools[name]
+		definitions = append(definitions, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: openai.FunctionDefinition{
+				Name:        tool.Name(),
+				Description: tool.Description(),
+				Parameters:  tool.Parameters(),
+			},
+		})
+	}
+	return definitions
+}
+
+// Execute 执行一次工具调用。出错时把错误作为结果返回给模型，让模型自行决定如何回复
+func (r *Registry) Execute(ctx context.Context, call openai.ToolCall) string {
+	tool, ok := r.tools[call.Function.Name]
+	if !ok {
+		return fmt.Sprintf("error: unknown tool %s", call.Function.Name)
+	}
+	result, err := tool.Execute(ctx, call.Function.Arguments)
+	if err != nil {
+		log.Printf("Tool %s failed: %v", call.Function.Name, err)
+		return fmt.Sprintf("error: %v", err)
+	}
+	return result
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Accumulator 把流式响应中分片到达的工具调用按 index 拼接完整
+type Accumulator struct {
+	calls map[int]*openai.ToolCall
+}
+
+// Add 合并一个流式分片中的工具调用
+func (a *Accumulator) Add(deltas []openai.ToolCall) {
+	if a.calls == nil {
+		a.calls = map[int]*openai.ToolCall{}
+	}
+	for _, delta := range deltas {
+		index := 0
+		if delta.Index != nil {
+			index = *delta.Index
+		}
+		call, ok := a.calls[index]
+		if !ok {
+			call = &openai.ToolCall{Type: openai.ToolTypeFunction}
+			a.calls[index] = call
+		}
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Type != "" {
+			call.Type = delta.Type
+		}
+		call.Function.Name += delta.Function.Name
+		call.Function.Arguments += delta.Function.Arguments
+	}
+}
+
+// Calls 按 index 顺序返回拼接完成的工具调用
+func (a *Accumulator) Calls() []openai.ToolCall {
+	indexes := make([]int, 0, len(a.calls))
+	for index := range a.calls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	calls := make([]openai.ToolCall, 0, len(indexes))
+	for _, index := range indexes {
+		call := *a.calls[index]
+		if strings.TrimSpace(call.Function.Arguments) == "" {
+			call.Function.Arguments = "{}"
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"context"
+	"duolaGPT/store"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+const (
+	DefaultCacheSize = 500
+	DefaultQueryTTL  = 6 * time.Hour
+	DefaultPageTTL   = 24 * time.Hour
+)
+
+// SearchCache 缓存搜索结果和页面正文，相同或只差标点、大小写的问题不再消耗搜索配额和重新抓取页面。
+// TTL 不大于 0 时不缓存对应的内容
+type SearchCache struct {
+	store    store.CacheStore
+	queryTTL time.Duration
+	pageTTL  time.Duration
+
+	queryHits, queryMisses atomic.Int64
+	pageHits, pageMisses   atomic.Int64
+}
+
+// Cache 当前使用的搜索缓存，main 中按配置创建
+var Cache = NewSearchCache(store.NewMemoryCacheStore(DefaultCacheSize), DefaultQueryTTL, DefaultPageTTL)
+
+func NewSearchCache(cacheStore store.CacheStore, queryTTL, pageTTL time.Duration) *SearchCache {
+	return &SearchCache{store: cacheStore, queryTTL: queryTTL, pageTTL: pageTTL}
+}
+
+// Search 返回缓存的搜索结果，未命中时调用 provider 并缓存成功的结果
+func (c *SearchCache) Search(ctx context.Context, provider SearchProvider, query string, page int) ([]SearchResult, error) {
+	if c.queryTTL <= 0 {
+		return provider.Search(ctx, query, page)
+	}
+	key := fmt.Sprintf("query:%s:%d:%s", provider.Name(), page, normalizeQuery(query))
+	var results []SearchResult
+	if c.load(key, &results) {
+		c.queryHits.Add(1)
+		return results, nil
+	}
+	c.queryMisses.Add(1)
+	results, err := provider.Search(ctx, query, page)
+	if err != nil {
+		return nil, err
+	}
+	c.save(key, results, c.queryTTL)
+	return results, nil
+}
+
+// Page 返回缓存的页面正文，未命中时抓取并提取正文。没有提取到正文时不缓存，下次重新抓取
+func (c *SearchCache) Page(ctx context.Context, link string) (string, error) {
+	key := "page:" + link
+	var content string
+	if c.pageTTL > 0 && c.load(key, &content) {
+		c.pageHits.Add(1)
+		return content, nil
+	}
+	c.pageMisses.Add(1)
+	htmlContent, err := fetchURLContent(ctx, link)
+	if err != nil {
+		return "", err
+	}
+	content, err = extractMainContent(htmlContent)
+	if err != nil {
+		return "", err
+	}
+	if c.pageTTL > 0 && content != "" {
+		c.save(key, content, c.pageTTL)
+	}
+	return content, nil
+}
+
+// Stats 返回启动以来的命中和未命中次数
+func (c *SearchCache) Stats() string {
+	return fmt.Sprintf("query hits %d, misses %d; page hits %d, misses %d",
+		c.queryHits.Load(), c.queryMisses.Load(), c.pageHits.Load(), c.pageMisses.Load())
+}
+
+func (c *SearchCache) load(key string, v any) bool {
+	entry, ok, err := c.store.Get(key)
+	if err != nil {
+		log.Printf("Failed to read search cache: %v", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(entry.Value, v); err != nil {
+		log.Printf("Failed to decode search cache entry %s: %v", key, err)
+		return false
+	}
+	return true
+}
+
+func (c *SearchCache) save(key string, v any, ttl time.Duration) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode search cache entry %s: %v", key, err)
+		return
+	}
+	if err := c.store.Set(key, store.CacheEntry{Value: data, ExpiresAt: time.Now().Add(ttl)}); err != nil {
+		log.Printf("Failed to write search cache: %v", err)
+	}
+}
+
+// normalizeQuery 忽略大小写、标点和多余的空白
+func normalizeQuery(query string) string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	})
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress 目标是本机、内网或保留地址，抓取网页时不允许连接
+var ErrForbiddenAddress = errors.New("address is not publicly routable")
+
+// reservedNets net.IP 的方法没有覆盖到的非公网地址段
+var reservedNets = parseCIDRs(
+	"0.0.0.0/8",     // 本网络
+	"100.64.0.0/10", // 运营商级 NAT
+	"192.0.0.0/24",  // IETF 协议分配
+	"198.18.0.0/15", // 基准测试
+	"240.0.0.0/4",   // 保留
+	"64:ff9b::/96",  // NAT64，可映射到任意 IPv4 地址
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// isPublicIP 判断地址是否可以抓取：拒绝回环、私有、链路本地、组播和未指定地址
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAddress 校验即将连接的 ip:port
+func checkAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+// checkHost 解析主机名并校验全部地址，用于经过代理、连接由代理完成的情况。
+// 这里的解析结果不会交给代理，不能防止 DNS 重新绑定
+func checkHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return fmt.Errorf("%w: %s (%s)", ErrForbiddenAddress, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// NewFetchClient 创建抓取网页使用的客户端，只允许连接公网地址。
+// 直连时在 DialContext 中校验解析后实际连接的地址，重定向和 DNS 重新绑定都无法绕过。
+// 配置了代理时连接的是代理本身，只能在每次请求（包括重定向）前解析并校验目标主机；
+// 代理会自己再解析一次，两次解析结果不同（DNS 重新绑定）时仍可能访问到内网，
+// 需要完全防护时应在代理上限制内网地址
+func NewFetchClient(proxyURL string) (*http.Client, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyURL == "" {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			return checkAddress(address)
+		}
+		transport.Proxy = nil
+	} else {
+		proxy, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if err := checkHost(req.Context(), req.URL.Hostname()); err != nil {
+				return nil, err
+			}
+			return proxy, nil
+		}
+	}
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/net/html/charset"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// fetchWorkers 同时抓取的页面数
+	fetchWorkers = 4
+	// fetchTimeout 单个页面的抓取时间上限
+	fetchTimeout = 8 * time.Second
+	// searchTimeout 一次搜索（包括抓取页面）的总时间上限
+	searchTimeout = 20 * time.Second
+	// maxSearchPages 最多翻阅的搜索结果页数
+	maxSearchPages = 3
+	// maxPageSize 页面正文的读取上限，超出部分直接丢弃
+	maxPageSize    = 2 << 20
+	fetchUserAgent = "Mozilla/5.0 (compatible; duolaGPT/1.0)"
+	// maxPageLength /url 抓取的页面交给模型的最大字符数
+	maxPageLength = 10000
+)
+
+// ErrNoContent 页面中没有提取到正文
+var ErrNoContent = errors.New("no readable content")
+
+// HTTPClient 抓取网页和 DuckDuckGo 结果页使用的客户端，main 中设置为 NewFetchClient 创建的
+// 带代理、只允许连接公网地址的客户端，需要在 NewSearchProvider 之前设置。
+// 客户端本身不设超时，由每次请求的 context 控制
+var HTTPClient = http.DefaultClient
+
+// fetchURLContent 抓取页面 HTML 并转换为 UTF-8，非 HTML 类型的内容（PDF、图片等）返回错误
+func fetchURLContent(ctx context.Context, targetURL string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", fetchUserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP request failed with status code %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !isHTML(contentType) {
+		return "", fmt.Errorf("unsupported content type %q", contentType)
+	}
+	// 按响应头或页面 meta 声明的编码转换为 UTF-8
+	body, err := charset.NewReader(io.LimitReader(resp.Body, maxPageSize), resp.Header.Get("Content-Type"))
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if _, err := io.Copy(&sb, body); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// isHTML 未声明类型时按 HTML 处理
+func isHTML(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// ExtractSummariesFromSearchResult 并发抓取每条搜索结果的页面正文，保留标题、链接和结果顺序。
+// 页面抓取或解析失败时使用搜索结果自带的摘要
+func ExtractSummariesFromSearchResult(ctx context.Context, searchResults []SearchResult) []SearchSource {
+	fetched := make([]SearchSource, len(searchResults))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < fetchWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				item := searchResults[i]
+				fetched[i] = SearchSource{Title: item.Title, Link: item.Link, Content: item.Snippet}
+				if mainContent, err := Cache.Page(ctx, item.Link); err != nil {
+					log.Printf("Failed to fetch %s: %v", item.Link, err)
+				} else if mainContent != "" {
+					fetched[i].Content = mainContent
+				}
+			}
+		}()
+	}
+	for i := range searchResults {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	sources := []SearchSource{}
+	for _, source := range fetched {
+		if strings.TrimSpace(source.Content) == "" {
+			continue
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// FetchPage 抓取单个页面的正文，用于回答关于指定网页的问题
+func FetchPage(ctx context.Context, link string) (SearchSource, error) {
+	content, err := Cache.Page(ctx, link)
+	if err != nil {
+		return SearchSource{}, err
+	}
+	if content == "" {
+		return SearchSource{}, ErrNoContent
+	}
+	return SearchSource{Title: link, Link: link, Content: truncateRunes(content, maxPageLength)}, nil
+}
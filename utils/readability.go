@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// removedSelector 不属于正文的元素，打分前直接删除。
+	// 不删除 form：有些站点（如 ASP.NET）把整个页面包在一个 form 里，只删除其中的控件
+	removedSelector = "script, style, noscript, template, iframe, svg, canvas, button, input, select, textarea, " +
+		"nav, aside, footer, header, [hidden], [aria-hidden=true], [role=navigation], [role=complementary], [role=contentinfo]"
+	// minContentLength 正文少于这么多字符时视为没有提取到，使用搜索摘要
+	minContentLength = 100
+	// minParagraphLength 参与打分的段落的最少字符数
+	minParagraphLength = 25
+	// maxLinkDensity 链接文字占比超过该值的列表和区块视为导航
+	maxLinkDensity = 0.5
+)
+
+var (
+	unlikelyCandidate = regexp.MustCompile(`(?i)advert|banner|breadcrumb|comment|consent|cookie|footer|masthead|menu|modal|navbar|navigation|popup|related|share|sidebar|social|sponsor|subscribe`)
+	maybeCandidate    = regexp.MustCompile(`(?i)article|body|content|entry|main|post|story|text`)
+)
+
+// blockElements 渲染文本时另起一行的元素
+var blockElements = map[string]bool{
+	"address": true, "article": true, "blockquote": true, "dd": true, "div": true, "dl": true, "dt": true,
+	"figcaption": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "hr": true,
+	"li": true, "main": true, "ol": true, "p": true, "section": true, "table": true, "tr": true, "ul": true,
+}
+
+// extractMainContent 参照 Readability 提取页面正文：删除导航、侧栏、脚本等元素，
+// 优先使用 article/main，否则按段落的长度和标点给父元素打分，取得分最高的区块。
+// 代码块保留原有换行，列表项以 "- " 开头。没有足够的正文时返回空字符串
+func extractMainContent(htmlContent string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return "", err
+	}
+
+	doc.Find(removedSelector).Remove()
+	doc.Find("body *").Each(func(_ int, s *goquery.Selection) {
+		if s.Is("article, main, pre, code, table, tbody, tr, td") {
+			return
+		}
+		attributes := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+		if unlikelyCandidate.MatchString(attributes) && !maybeCandidate.MatchString(attributes) {
+			s.Remove()
+		}
+	})
+
+	root := mainCandidate(doc)
+	root.Find("ul, ol, div, table").Each(func(_ int, s *goquery.Selection) {
+		if linkDensity(s) > maxLinkDensity {
+			s.Remove()
+		}
+	})
+
+	var w textWriter
+	for _, node := range root.Nodes {
+		w.walk(node)
+	}
+	w.flush()
+	text := strings.Join(w.lines, "\n")
+	if utf8.RuneCountInString(text) < minContentLength {
+		return "", nil
+	}
+	return text, nil
+}
+
+// mainCandidate 返回正文所在的元素
+func mainCandidate(doc *goquery.Document) *goquery.Selection {
+	// 页面明确标注的正文区域优先
+	var best *goquery.Selection
+	bestLength := 0
+	doc.Find("article, main, [role=main]").Each(func(_ int, s *goquery.Selection) {
+		if length := textLength(s); length > bestLength {
+			best, bestLength = s, length
+		}
+	})
+	if best != nil && bestLength >= minContentLength {
+		return best
+	}
+
+	// 段落的得分加给父元素，一半加给祖父元素
+	var candidates []*html.Node
+	scores := map[*html.Node]float64{}
+	addScore := func(node *html.Node, score float64) {
+		if node == nil || node.Type != html.ElementNode {
+			return
+		}
+		if _, ok := scores[node]; !ok {
+			candidates = append(candidates, node)
+		}
+		scores[node] += score
+	}
+	doc.Find("p, pre, li, td, blockquote").Each(func(_ int, s *goquery.Selection) {
+		length := textLength(s)
+		if length < minParagraphLength {
+			return
+		}
+		text := s.Text()
+		score := 1 + float64(strings.Count(text, ",")+strings.Count(text, "，")+strings.Count(text, "。")) + math.Min(float64(length)/100, 3)
+		parent := s.Get(0).Parent
+		addScore(parent, score)
+		if parent != nil {
+			addScore(parent.Parent, score/2)
+		}
+	})
+
+	bestScore := 0.0
+	for _, node := range candidates {
+		candidate := doc.FindNodes(node)
+		// 链接越多越可能是导航或推荐列表
+		score := scores[node] * (1 - linkDensity(candidate))
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	if best != nil {
+		return best
+	}
+	if body := doc.Find("body"); body.Length() > 0 {
+		return body
+	}
+	return doc.Selection
+}
+
+func textLength(s *goquery.Selection) int {
+	return utf8.RuneCountInString(cleanText(s.Text()))
+}
+
+// linkDensity 链接文字占全部文字的比例
+func linkDensity(s *goquery.Selection) float64 {
+	length := textLength(s)
+	if length == 0 {
+		return 0
+	}
+	linkLength := 0
+	s.Find("a").Each(func(_ int, a *goquery.Selection) {
+		linkLength += textLength(a)
+	})
+	return float64(linkLength) / float64(length)
+}
+
+// textWriter 把 DOM 渲染为纯文本，块级元素各占一行
+type textWriter struct {
+	lines  []string
+	line   strings.Builder
+	prefix string
+}
+
+func (w *textWriter) flush() {
+	if text := cleanText(w.line.String()); text != "" {
+		w.lines = append(w.lines, w.prefix+text)
+		w.prefix = ""
+	}
+	w.line.Reset()
+}
+
+func (w *textWriter) walk(node *html.Node) {
+	switch node.Type {
+	case html.TextNode:
+		w.line.WriteString(node.Data)
+		return
+	case html.ElementNode, html.DocumentNode:
+	default:
+		return
+	}
+
+	switch node.Data {
+	case "pre":
+		w.flush()
+		if code := strings.Trim(nodeText(node), "\n"); strings.TrimSpace(code) != "" {
+			w.lines = append(w.lines, code)
+		}
+		return
+	case "br":
+		w.flush()
+		return
+	case "td", "th":
+		w.line.WriteString(" ")
+	}
+
+	block := blockElements[node.Data]
+	if block {
+		w.flush()
+		if node.Data == "li" {
+			w.prefix = "- "
+		}
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		w.walk(child)
+	}
+	if block {
+		w.flush()
+		w.prefix = ""
+	}
+}
+
+// nodeText 返回元素内的全部文字，保留空白
+func nodeText(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+	var builder strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		builder.WriteString(nodeText(child))
+	}
+	return builder.String()
+}
@@ -0,0 +1,273 @@
+package utils
+
+import (
+	"context"
+	"duolaGPT/conf"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	SearchGoogle     = "google"
+	SearchDuckDuckGo = "duckduckgo"
+	SearchSearXNG    = "searxng"
+)
+
+// ErrQuotaExceeded 搜索接口的配额已用尽
+var ErrQuotaExceeded = errors.New("search quota exceeded")
+
+// SearchResult 一条搜索结果
+type SearchResult struct {
+	Title   string
+	Link    string
+	Snippet string
+}
+
+// SearchProvider 搜索后端，page 从 1 开始，没有更多结果时返回空切片
+type SearchProvider interface {
+	Name() string
+	Search(ctx context.Context, query string, page int) ([]SearchResult, error)
+}
+
+// Search 当前使用的搜索后端，main 中按配置创建
+var Search SearchProvider = NewFallbackSearch(NewDuckDuckGoSearch(HTTPClient))
+
+// NewSearchProvider 按 search_providers 的顺序创建搜索后端，前一个出错或配额用尽时使用下一个。
+// 未配置时优先使用 Google（配置了 key 和 engine id），再退回 DuckDuckGo
+func NewSearchProvider(config conf.Config, httpClient *http.Client) (SearchProvider, error) {
+	names := config.SearchProviders
+	if len(names) == 0 {
+		if config.GoogleSearchKey != "" && config.GoogleSearchEngineID != "" {
+			names = append(names, SearchGoogle)
+		}
+		names = append(names, SearchDuckDuckGo)
+	}
+
+	var providers []SearchProvider
+	for _, name := range names {
+		switch name {
+		case SearchGoogle:
+			if config.GoogleSearchKey == "" || config.GoogleSearchEngineID == "" {
+				return nil, errors.New("google search requires google_search_key and google_search_engine_id")
+			}
+			providers = append(providers, NewGoogleSearch(config.GoogleSearchKey, config.GoogleSearchEngineID, httpClient))
+		case SearchDuckDuckGo:
+			// DuckDuckGo 的结果页与搜索结果页面一样抓取, 使用同一个客户端
+			providers = append(providers, NewDuckDuckGoSearch(HTTPClient))
+		case SearchSearXNG:
+			if config.SearxngURL == "" {
+				return nil, errors.New("searxng search requires searxng_url")
+			}
+			providers = append(providers, NewSearXNGSearch(config.SearxngURL, httpClient))
+		default:
+			return nil, fmt.Errorf("unknown search provider %s", name)
+		}
+	}
+	return NewFallbackSearch(providers...), nil
+}
+
+// FallbackSearch 依次尝试多个搜索后端
+type FallbackSearch struct {
+	providers []SearchProvider
+}
+
+func NewFallbackSearch(providers ...SearchProvider) *FallbackSearch {
+	return &FallbackSearch{providers: providers}
+}
+
+func (s *FallbackSearch) Name() string {
+	var names []string
+	for _, provider := range s.providers {
+		names = append(names, provider.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (s *FallbackSearch) Search(ctx context.Context, query string, page int) ([]SearchResult, error) {
+	var err error
+	for _, provider := range s.providers {
+		var results []SearchResult
+		results, err = provider.Search(ctx, query, page)
+		if err == nil {
+			return results, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("Search with %s failed, trying next provider: %v", provider.Name(), err)
+	}
+	if err == nil {
+		err = errors.New("no search provider configured")
+	}
+	return nil, err
+}
+
+// GoogleSearch Google Custom Search JSON API，每天有免费配额限制
+type GoogleSearch struct {
+	apiKey         string
+	searchEngineID string
+	client         *http.Client
+}
+
+func NewGoogleSearch(apiKey, searchEngineID string, client *http.Client) *GoogleSearch {
+	return &GoogleSearch{apiKey: apiKey, searchEngineID: searchEngineID, client: client}
+}
+
+func (s *GoogleSearch) Name() string {
+	return SearchGoogle
+}
+
+func (s *GoogleSearch) Search(ctx context.Context, query string, page int) ([]SearchResult, error) {
+	// start 是结果的序号，每页 10 条
+	searchURL := googleSearchURL(s.apiKey, s.searchEngineID, query, (page-1)*10+1, 10, "lang_zh-CN")
+	var searchResult GSearchResult
+	if err := getJSON(ctx, s.client, searchURL, &searchResult); err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, 0, len(searchResult.Items))
+	for _, item := range searchResult.Items {
+		results = append(results, SearchResult{Title: item.Title, Link: item.Link, Snippet: item.Snippet})
+	}
+	return results, nil
+}
+
+// duckDuckGoURL DuckDuckGo 不需要 JavaScript 的 HTML 搜索页面
+const duckDuckGoURL = "https://html.duckduckgo.com/html/"
+
+// DuckDuckGoSearch 解析 DuckDuckGo 的 HTML 搜索页面，不需要 key，只有第一页结果
+type DuckDuckGoSearch struct {
+	client *http.Client
+}
+
+func NewDuckDuckGoSearch(client *http.Client) *DuckDuckGoSearch {
+	return &DuckDuckGoSearch{client: client}
+}
+
+func (s *DuckDuckGoSearch) Name() string {
+	return SearchDuckDuckGo
+}
+
+func (s *DuckDuckGoSearch) Search(ctx context.Context, query string, page int) ([]SearchResult, error) {
+	if page > 1 {
+		return nil, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, duckDuckGoURL+"?q="+url.QueryEscape(query), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", fetchUserAgent)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	// 触发人机验证时返回 202 和验证页面
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("%w: %s", ErrQuotaExceeded, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	doc.Find(".result").Each(func(_ int, result *goquery.Selection) {
+		if result.HasClass("result--ad") {
+			return
+		}
+		title := result.Find("a.result__a").First()
+		link := duckDuckGoLink(title.AttrOr("href", ""))
+		if link == "" {
+			return
+		}
+		results = append(results, SearchResult{
+			Title:   cleanText(title.Text()),
+			Link:    link,
+			Snippet: cleanText(result.Find(".result__snippet").First().Text()),
+		})
+	})
+	return results, nil
+}
+
+// duckDuckGoLink 结果链接经过 DuckDuckGo 跳转，真实地址在 uddg 参数中
+func duckDuckGoLink(href string) string {
+	if strings.HasPrefix(href, "//") {
+		href = "https:" + href
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if target := u.Query().Get("uddg"); target != "" {
+		return target
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
+
+// SearXNGSearch 自建 SearXNG 实例的 JSON 接口，需要在实例的 settings.yml 中启用 json 格式
+type SearXNGSearch struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewSearXNGSearch(baseURL string, client *http.Client) *SearXNGSearch {
+	return &SearXNGSearch{baseURL: strings.TrimRight(baseURL, "/"), client: client}
+}
+
+func (s *SearXNGSearch) Name() string {
+	return SearchSearXNG
+}
+
+func (s *SearXNGSearch) Search(ctx context.Context, query string, page int) ([]SearchResult, error) {
+	searchURL := fmt.Sprintf("%s/search?q=%s&format=json&pageno=%d", s.baseURL, url.QueryEscape(query), page)
+	var response struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	if err := getJSON(ctx, s.client, searchURL, &response); err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, 0, len(response.Results))
+	for _, result := range response.Results {
+		results = append(results, SearchResult{Title: result.Title, Link: result.URL, Snippet: result.Content})
+	}
+	return results, nil
+}
+
+// getJSON 请求并解析 JSON 响应，429 和 403 视为配额用尽
+func getJSON(ctx context.Context, client *http.Client, targetURL string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s", ErrQuotaExceeded, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("json unmarshaling failed: %v", err)
+	}
+	return nil
+}
@@ -1,16 +1,14 @@
 package utils
 
 import (
+	"context"
 	"duolaGPT/conf"
 	"duolaGPT/variables"
-	"encoding/json"
 	"fmt"
-	"github.com/PuerkitoBio/goquery"
-	"io"
-	"io/ioutil"
-	"net/http"
+	"log"
 	"net/url"
 	"strings"
+	"unicode/utf8"
 )
 
 // GSearchResult 结构体用于解析Google Custom Search API的响应
@@ -22,148 +20,86 @@ type GSearchResult struct {
 	} `json:"items"`
 }
 
-func PerformGoogleSearch(apiKey, searchEngineID, searchQuery string, start, num int, language string, proxyURL string) (*GSearchResult, error) {
+func googleSearchURL(apiKey, searchEngineID, searchQuery string, start, num int, language string) string {
 	baseURL := "https://www.googleapis.com/customsearch/v1"
+	return fmt.Sprintf("%s?key=%s&cx=%s&q=%s&start=%d&num=%d&lr=%s", baseURL, apiKey, searchEngineID, url.QueryEscape(searchQuery), start, num, language)
+}
 
-	// 群聊截取对话文本
+// cleanQuery 群聊截取对话文本，去掉开头提及机器人的部分
+func cleanQuery(searchQuery string) string {
 	if strings.Contains(searchQuery, "@") {
 		parts := strings.Fields(searchQuery)
 		if len(parts) > 1 {
-			searchQuery = strings.Join(parts[1:], " ")
-		}
-	}
-	searchURL := fmt.Sprintf("%s?key=%s&cx=%s&q=%s&start=%d&num=%d&lr=%s", baseURL, apiKey, searchEngineID, url.QueryEscape(searchQuery), start, num, language)
-
-	var client *http.Client
-	if proxyURL != "" {
-		proxy, err := url.Parse(proxyURL)
-		if err != nil {
-			return nil, fmt.Errorf("invalid proxy URL: %v", err)
+			return strings.Join(parts[1:], " ")
 		}
-		transport := &http.Transport{Proxy: http.ProxyURL(proxy)}
-		client = &http.Client{Transport: transport}
-	} else {
-		client = &http.Client{}
-	}
-
-	resp, err := client.Get(searchURL)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading response body failed: %v", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad status: %s", resp.Status)
-	}
-
-	// 解析JSON响应
-	var searchResult GSearchResult
-	if err := json.Unmarshal(body, &searchResult); err != nil {
-		return nil, fmt.Errorf("json unmarshaling failed: %v", err)
 	}
-
-	return &searchResult, nil
-}
-
-func fetchURLContent(targetURL string, proxyURL string) (string, error) {
-	client := &http.Client{}
-
-	if proxyURL != "" {
-		proxy, err := url.Parse(proxyURL)
-		if err != nil {
-			return "", fmt.Errorf("failed to parse proxy URL: %v", err)
-		}
-		client.Transport = &http.Transport{
-			Proxy: http.ProxyURL(proxy),
-		}
-	}
-	resp, err := client.Get(targetURL)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP request failed with status code %d", resp.StatusCode)
-	}
-	var sb strings.Builder
-	_, err = io.Copy(&sb, resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	return sb.String(), nil
+	return searchQuery
 }
 
 func cleanText(text string) string {
 	return strings.Join(strings.Fields(text), " ")
 }
 
-func extractMainContent(htmlContent string) (string, error) {
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
-	if err != nil {
-		return "", err
+// CheckForKeywords 检查用户输入是否包含 search_keywords 中的关键字，未配置时使用默认关键字，不区分大小写
+func CheckForKeywords(userInput string, config conf.Config) bool {
+	keywords := config.SearchKeywords
+	if len(keywords) == 0 {
+		keywords = variables.TriggerKeywords
 	}
-
-	var textContent strings.Builder
-
-	// 查找所有的<p>标签并遍历
-	doc.Find("p").Each(func(i int, s *goquery.Selection) {
-		// 对于每个<p>标签，获取其文本内容，并追加到textContent中
-		// 这里使用了一个换行符来分隔每个段落，根据需要可以移除或替换
-		textContent.WriteString(s.Text() + "\n")
-	})
-
-	// 如果没有找到<p>标签，可能需要返回错误或空字符串
-	if textContent.Len() == 0 {
-		return "", fmt.Errorf("no <p> tags found")
+	userInput = strings.ToLower(userInput)
+	for _, keyword := range keywords {
+		if keyword != "" && strings.Contains(userInput, strings.ToLower(keyword)) {
+			return true
+		}
 	}
+	return false
+}
 
-	// 过滤不符合预期的杂质内容(可能会筛选到无关的p标签)
-	if len(cleanText(textContent.String())) < 200 {
-		return "", nil
-	}
+// SearchSources 搜索 query 并抓取结果页面的正文，正文合计不超过 limit 个字符。
+// 整个过程最多 searchTimeout，ctx 取消（如 /stop）时立即返回已得到的来源
+func SearchSources(ctx context.Context, query string, limit int) []SearchSource {
+	ctx, cancel := context.WithTimeout(ctx, searchTimeout)
+	defer cancel()
 
-	return cleanText(textContent.String()), nil
-}
-func ExtractSummariesFromSearchResult(searchResult *GSearchResult, proxyUrl string) []string {
-	summaries := []string{}
+	var sources []SearchSource
+	seen := map[string]bool{}
+	total := 0
 
-	for _, item := range searchResult.Items {
-		htmlContent, err := fetchURLContent(item.Link, proxyUrl)
+	query = cleanQuery(query)
+	// 循环直到文本长度达到 limit、没有更多的搜索结果或超时
+	for page := 1; total < limit && page <= maxSearchPages; page++ {
+		searchResults, err := Cache.Search(ctx, Search, query, page)
 		if err != nil {
-			fmt.Printf("fetchURLContent error: %v\n", err)
-			continue
+			log.Printf("Failed to search with %s: %v", Search.Name(), err)
+			break
 		}
-
-		mainContent, err := extractMainContent(htmlContent)
-		if err != nil {
-			fmt.Printf("extractMainContent error: %v\n", err)
-			continue
+		// 如果没有搜索结果，跳出循环
+		if len(searchResults) == 0 {
+			break
 		}
 
-		//summary := fmt.Sprintf("Title: %s\nLink: %s\nContent: %s\n", item.Title, item.Link, mainContent)
-		summary := fmt.Sprintf("%s\n", mainContent)
-		summaries = append(summaries, summary)
-	}
-
-	return summaries
-}
-
-// CheckForKeywords 检查用户输入是否包含关键字
-func CheckForKeywords(userInput string, config conf.Config) bool {
-	if config.GoogleSearchEngineID == "" {
-		return false
-	}
-	for _, keyword := range variables.TriggerKeywords {
-		if strings.Contains(strings.ToLower(userInput), keyword) {
-			return true
+		for _, source := range ExtractSummariesFromSearchResult(ctx, searchResults) {
+			// 不同页的结果可能重复，同一链接只保留一次
+			if seen[source.Link] {
+				continue
+			}
+			seen[source.Link] = true
+			// 单个页面的正文不超过 maxSourceLength，给其他来源留出空间
+			remaining := limit - total
+			if remaining > maxSourceLength {
+				remaining = maxSourceLength
+			}
+			source.Content = truncateRunes(source.Content, remaining)
+			sources = append(sources, source)
+			total += utf8.RuneCountInString(source.Content)
+			if total >= limit {
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			break
 		}
 	}
-	return false
+	log.Printf("Search cache: %s", Cache.Stats())
+	return sources
 }
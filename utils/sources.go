@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxSourceLength 单个来源交给模型的最大字符数
+	maxSourceLength = 3000
+	// sourcesHeading 来源列表的标题，用于从回复中识别并去掉来源列表
+	sourcesHeading = "\n\n**来源**\n"
+)
+
+// SearchSource 一条带正文的搜索结果，用于生成带引用的回答
+type SearchSource struct {
+	Title   string
+	Link    string
+	Content string
+}
+
+// SearchPrompt 构造联网搜索后的提问：保留用户的原始问题，并附上编号的搜索结果，要求模型按编号引用
+func SearchPrompt(question string, sources []SearchSource) string {
+	var builder strings.Builder
+	builder.WriteString("Answer the question below using the numbered web search results. ")
+	builder.WriteString("Cite the results you rely on inline with their numbers in square brackets, e.g. [1] or [2][3]. ")
+	builder.WriteString("If the results do not contain the answer, say so and answer from your own knowledge.\n\n")
+	fmt.Fprintf(&builder, "Question: %s\n\n", question)
+	numbers := make([]int, len(sources))
+	for i := range sources {
+		numbers[i] = i + 1
+	}
+	builder.WriteString(FormatSources(sources, numbers))
+	return builder.String()
+}
+
+// PagePrompt 构造针对单个网页的提问，网页编号为 [1]，回复后同样附上来源
+func PagePrompt(question string, page SearchSource) string {
+	var builder strings.Builder
+	builder.WriteString("Answer the question below using the web page provided. ")
+	builder.WriteString("Cite it inline as [1] when you rely on it. ")
+	builder.WriteString("If the page does not contain the answer, say so.\n\n")
+	fmt.Fprintf(&builder, "Question: %s\n\n", question)
+	fmt.Fprintf(&builder, "Web page:\n\n[1] %s\nURL: %s\n%s\n", page.Title, page.Link, page.Content)
+	return builder.String()
+}
+
+// FormatSources 将搜索结果格式化为编号列表，numbers[i] 为第 i 条结果的编号
+func FormatSources(sources []SearchSource, numbers []int) string {
+	var builder strings.Builder
+	builder.WriteString("Search results:\n")
+	for i, source := range sources {
+		fmt.Fprintf(&builder, "\n[%d] %s\nURL: %s\n%s\n", numbers[i], source.Title, source.Link, source.Content)
+	}
+	return builder.String()
+}
+
+var citationRe = regexp.MustCompile(`\[(\d+)\]`)
+
+// SourcesFooter 生成追加在回复末尾的来源列表（Markdown 链接）。回复中引用了编号时只列出被引用的来源
+func SourcesFooter(reply string, sources []SearchSource) string {
+	cited := map[int]bool{}
+	for _, match := range citationRe.FindAllStringSubmatch(reply, -1) {
+		if n, err := strconv.Atoi(match[1]); err == nil && n >= 1 && n <= len(sources) {
+			cited[n] = true
+		}
+	}
+
+	var builder strings.Builder
+	builder.WriteString(sourcesHeading)
+	for i, source := range sources {
+		if len(cited) > 0 && !cited[i+1] {
+			continue
+		}
+		title := strings.NewReplacer("[", "(", "]", ")", "\n", " ").Replace(strings.TrimSpace(source.Title))
+		if title == "" {
+			title = source.Link
+		}
+		link := strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(source.Link)
+		fmt.Fprintf(&builder, "%d. [%s](%s)\n", i+1, title, link)
+	}
+	return builder.String()
+}
+
+// WithoutSourcesFooter 去掉回复末尾的来源列表，例如用于语音合成
+func WithoutSourcesFooter(reply string) string {
+	if i := strings.LastIndex(reply, sourcesHeading); i >= 0 {
+		return reply[:i]
+	}
+	return reply
+}
+
+// truncateRunes 按字符截断文本，不会截断多字节字符
+func truncateRunes(text string, limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit])
+}
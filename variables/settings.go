@@ -0,0 +1,86 @@
+package variables
+
+import (
+	"bytes"
+	"duolaGPT/store"
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// SettingsRepository 管理每个会话的 User 设置。
+// 只有可序列化的字段会写入 store，CurrentContext 等运行时字段只保存在内存中。
+type SettingsRepository struct {
+	mu        sync.Mutex
+	store     store.SettingsStore
+	users     map[int64]User
+	persisted map[int64][]byte
+}
+
+func NewSettingsRepository(settingsStore store.SettingsStore) *SettingsRepository {
+	return &SettingsRepository{
+		store:     settingsStore,
+		users:     make(map[int64]User),
+		persisted: make(map[int64][]byte),
+	}
+}
+
+// Get 返回会话设置，首次访问时从 store 中加载
+func (r *SettingsRepository) Get(chatID int64) User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.load(chatID)
+}
+
+// Set 覆盖会话设置，持久化字段有变化时写入 store
+func (r *SettingsRepository) Set(chatID int64, user User) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.save(chatID, user)
+}
+
+// Update 在锁内读取并修改会话设置，返回修改后的值
+func (r *SettingsRepository) Update(chatID int64, fn func(user *User)) User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := r.load(chatID)
+	fn(&user)
+	r.save(chatID, user)
+	return user
+}
+
+func (r *SettingsRepository) load(chatID int64) User {
+	if user, ok := r.users[chatID]; ok {
+		return user
+	}
+	var user User
+	data, err := r.store.Load(chatID)
+	if err != nil {
+		log.Printf("Failed to load settings %d: %v", chatID, err)
+	} else if data != nil {
+		if err := json.Unmarshal(data, &user); err != nil {
+			log.Printf("Failed to decode settings %d: %v", chatID, err)
+		}
+	}
+	r.users[chatID] = user
+	r.persisted[chatID] = data
+	return user
+}
+
+func (r *SettingsRepository) save(chatID int64, user User) {
+	r.users[chatID] = user
+	data, err := json.Marshal(user)
+	if err != nil {
+		log.Printf("Failed to encode settings %d: %v", chatID, err)
+		return
+	}
+	// 流式输出时会频繁更新运行时字段，只在持久化字段变化时写入
+	if bytes.Equal(data, r.persisted[chatID]) {
+		return
+	}
+	if err := r.store.Save(chatID, data); err != nil {
+		log.Printf("Failed to save settings %d: %v", chatID, err)
+		return
+	}
+	r.persisted[chatID] = data
+}
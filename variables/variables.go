@@ -2,31 +2,46 @@ package variables
 
 import (
 	"context"
-	"github.com/sashabaranov/go-openai"
+	"duolaGPT/store"
 )
 
-var ConversationHistory = make(map[int64][]openai.ChatCompletionMessage)
-var UserSettingsMap = make(map[int64]User)
+// ConversationHistory 默认使用内存存储，main 中可按配置替换为持久化存储
+var ConversationHistory store.ConversationStore = store.NewMemoryConversationStore()
+
+// Documents 会话上传的文档，默认使用内存存储，main 中可按配置替换为持久化存储
+var Documents store.DocumentStore = store.NewMemoryDocumentStore()
+
+// UserSettingsMap 默认使用内存存储，main 中可按配置替换为持久化存储
+var UserSettingsMap = NewSettingsRepository(store.NewMemorySettingsStore())
 
 const (
-	GPT4Model                   = "gpt-4-1106-preview"
-	GPT35TurboModel             = "gpt-3.5-turbo-16k"
-	GPTPICModel                 = "dall-e-3"
-	StateDefault                = ""
-	StateWaitingForSystemPrompt = "waiting_for_system_prompt"
-	DefaultSystemPrompt         = "You are ChatGPT, a large language model trained by OpenAI."
-	DefaultModel                = GPT35TurboModel
+	StateDefault                 = ""
+	StateWaitingForSystemPrompt  = "waiting_for_system_prompt"
+	StateWaitingForTranscription = "waiting_for_transcription"
+	DefaultSystemPrompt          = "You are ChatGPT, a large language model trained by OpenAI."
+	SummaryPrefix                = "Summary of the earlier conversation so far: "
+	// LanguagePlaceholder system prompt 中回复语言的占位符，每次请求时替换为会话设置的语言
+	LanguagePlaceholder = "{language}"
+	// DefaultLanguage 未设置回复语言时使用的语言
+	DefaultLanguage = "Chinese"
 )
 
+// User 会话设置，json 标记为 "-" 的字段仅在运行时有效，不会被持久化
 type User struct {
-	Model                string
-	SystemPrompt         string
-	State                string
-	CurrentContext       *context.CancelFunc
-	CurrentMessageBuffer string
-	MessageCount         int
+	Model                string              `json:"model,omitempty"`
+	SystemPrompt         string              `json:"system_prompt,omitempty"`
+	State                string              `json:"state,omitempty"`
+	AutoSummary          bool                `json:"auto_summary,omitempty"`
+	Temperature          *float32            `json:"temperature,omitempty"`
+	SearchDisabled       bool                `json:"search_disabled,omitempty"`
+	Language             string              `json:"language,omitempty"`
+	VoiceMode            bool                `json:"voice_mode,omitempty"`
+	KnowledgeDisabled    bool                `json:"knowledge_disabled,omitempty"`
+	CurrentContext       *context.CancelFunc `json:"-"`
+	CurrentMessageBuffer string              `json:"-"`
 }
 
+// TriggerKeywords 默认的自动联网搜索关键字，配置了 search_keywords 时使用配置的关键字
 var TriggerKeywords = []string{
 	"搜索", "?", "??", "？", "？？",
 	// 更多关键字...
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/subtle"
+	"duolaGPT/conf"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// registerWebhook 调用 setWebhook 注册回调地址。
+// telegram-bot-api v5.5.1 的 WebhookConfig 不支持 secret_token，这里直接拼装参数。
+func registerWebhook(bot *tgbotapi.BotAPI, msgConf conf.Config) error {
+	params := make(tgbotapi.Params)
+	params["url"] = msgConf.WebhookURL
+	params["secret_token"] = msgConf.WebhookSecret
+	if msgConf.WebhookCert != "" {
+		// 自签名证书需要把公钥一并上传给 Telegram
+		_, err := bot.UploadFiles("setWebhook", params, []tgbotapi.RequestFile{{
+			Name: "certificate",
+			Data: tgbotapi.FilePath(msgConf.WebhookCert),
+		}})
+		return err
+	}
+	_, err := bot.MakeRequest("setWebhook", params)
+	return err
+}
+
+// ensureWebhook 回调地址已经注册时不再调用 setWebhook，多个副本同时启动时不会互相覆盖。
+// webhook_register 为 true 时总是重新注册，用于更换 secret 或证书之后
+func ensureWebhook(bot *tgbotapi.BotAPI, msgConf conf.Config) error {
+	if !msgConf.WebhookRegister {
+		info, err := bot.GetWebhookInfo()
+		if err != nil {
+			return err
+		}
+		if info.URL == msgConf.WebhookURL {
+			log.Printf("Webhook already registered, skipping setWebhook")
+			return nil
+		}
+	}
+	return registerWebhook(bot, msgConf)
+}
+
+// startWebhook 注册 webhook 并启动 HTTP 服务，校验 secret token 后把更新写入返回的 channel
+func startWebhook(bot *tgbotapi.BotAPI, msgConf conf.Config) (tgbotapi.UpdatesChannel, *http.Server, error) {
+	publicURL, err := url.Parse(msgConf.WebhookURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	path := publicURL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	// 所有副本必须使用同一个 secret，否则只有最后注册的副本能通过校验
+	if msgConf.WebhookSecret == "" {
+		return nil, nil, errors.New("webhook_secret is required when webhook_url is set")
+	}
+
+	updates := make(chan tgbotapi.Update, bot.Buffer)
+	stopping := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretTokenHeader)), []byte(msgConf.WebhookSecret)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		update, err := bot.HandleUpdate(r)
+		if err != nil {
+			log.Printf("Failed to decode webhook update: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		select {
+		case updates <- *update:
+		case <-stopping:
+			// 返回错误让 Telegram 稍后重新投递，不在关闭过程中丢弃更新
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		}
+	})
+
+	listen := msgConf.WebhookListen
+	if listen == "" {
+		listen = ":8443"
+	}
+	server := &http.Server{Addr: listen, Handler: mux}
+	server.RegisterOnShutdown(func() { close(stopping) })
+	go func() {
+		var err error
+		if msgConf.WebhookCert != "" && msgConf.WebhookKey != "" {
+			err = server.ListenAndServeTLS(msgConf.WebhookCert, msgConf.WebhookKey)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Webhook server failed: %v", err)
+		}
+	}()
+
+	if err := ensureWebhook(bot, msgConf); err != nil {
+		server.Close()
+		return nil, nil, err
+	}
+	log.Printf("Webhook listening on %s%s", listen, path)
+	return updates, server, nil
+}